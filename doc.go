@@ -55,6 +55,80 @@ packages, and the test code will have the proper fmt, the mocked fmt as mockfmt,
 and the mocked external package using it's own name (which will assume it ext,
 for the purposes of this documentation).
 
+A mock mark normally mocks everything in the package, which isn't always
+wanted for a large package such as os.  Instead, the functions and methods to be
+mocked can be listed, or the ones that should be left alone:
+
+ import (
+ 	"os" // mock(Open,Stat,*File.Close)
+
+ 	"example.com/some/external/package" // mock(except=Getenv)
+ )
+
+Anything not mocked by the mark calls the real code, as if it had been passed
+to DisableMock, and can still be mocked later using EnableMock.
+
+Since comments on imports can easily be lost when the imports are reformatted,
+imports can also be marked using directives in any of the test files (before
+the end of the imports), which apply to the imports in all of the test files of
+the package:
+
+ //withmock:mock example.com/some/external/package
+ //withmock:mock os(Open,Stat)
+ //withmock:replace example.com/slow/database => example.com/fake/database
+
+These behave exactly as if the same mark had been put on the import, though a
+mark on the import itself takes priority (any other comment on the import is
+ignored).  A warning is printed for a directive naming a package that none of
+the test files import.
+
+Any form of import can be marked.  With a dot import MOCK and EXPECT are used
+unqualified, and a mocked blank import simply has mocking disabled, since the
+test code has no way to control it.  An import path starting with "_mock_/"
+(e.g. "_mock_/os") is treated as marked "// mock", and the prefix is removed
+when the code is built.
+
+Instead of marking imports in the test code, the way that a package is handled
+can also be set in the config file (given using the -c option), so that the
+policy for a whole project can be kept in one place:
+
+ mocks:
+   example.com/some/external/package:
+     mode: mock
+   example.com/slow/database:
+     mode: replace
+     with: example.com/fake/database
+   example.com/fragile/package:
+     mode: exclude
+   example.com/extra/tool:
+     mode: link
+
+A mode of mock is the same as marking every import of the package with a mock
+comment, and replace uses the package given by "with" in place of the real one.
+Packages with a mode of exclude are never mocked, even if marked (like the
+packages listed in the file given by -exclude), and packages with a mode of link
+are installed even if nothing imports them (like -P).  The mode can't be set for
+DEFAULT.
+
+The packages in the config file, and in the files given to -exclude and -P, can
+also be given as patterns, so that one entry can cover a whole SDK.  In a
+pattern "..." matches any string, "*" matches any string without a "/" and "?"
+matches any single character other than "/".  A trailing "/..." also matches the
+path without it, so:
+
+ mocks:
+   example.com/sdk/...:
+     loose: zero
+   example.com/legacy-*:
+     mode: exclude
+
+applies to example.com/sdk and all of the packages below it, and excludes any
+package directly inside example.com with a name starting with "legacy-".  Where
+more than one entry matches a package, an exact match is used if there is one,
+otherwise the pattern with the most non-wildcard characters is used (ties go to
+the pattern that sorts first).  Only the chosen entry is merged with DEFAULT.
+A pattern can't be used with mode replace.
+
 Using Mocks
 
 The generated mock code behaves much like the code generated by gomock's mockgen
@@ -63,9 +137,9 @@ differences, due to the whole package nature.
 
 The first thing to do with a mocked package is to set the controller.  This
 needs to be done before any mocked method or function is called or expectation
-is set - otherwise the generated code will cause a panic.  To set the
-controller, we use the special mock object returned by the MOCK() function, and
-call it's SetController method:
+is set - otherwise the generated code will panic, with a message naming the
+function that was called.  To set the controller, we use the special mock
+object returned by the MOCK() function, and call it's SetController method:
 
  // Create a gomock controller, and arrange for it's finish to be called
  ctrl := gomock.NewController(t)
@@ -77,6 +151,12 @@ call it's SetController method:
  // Setup the ext mock package
  ext.MOCK().SetController(ctrl)
 
+If code in a mocked package may be called before the test has a chance to set
+the controller, then setting "no_controller: real" in the config file for that
+package will make such calls go to the real code instead.  This doesn't apply to
+the mocks of interfaces, which have no real code to call, so using one of those
+before the controller is set always panics.
+
 Once you have set the controller then you can set your mock expectations, either
 using the EXPECT() function for function expectations, or the EXPECT() method
 for any method expectations.  For example, if there was a type called
@@ -98,6 +178,357 @@ UsefulType instance:
  // And now, call the code under test
  importantFunction(ut)
 
+If an expectation returns a value of the wrong type (e.g. from a DoAndReturn
+function), then the test is failed with a message naming the function and the
+expected and actual types, rather than the mock silently returning a zero
+value.
+
+Mock versions of the interfaces in a mocked package are also generated.  Some
+interfaces can't be mocked, such as constraints containing type sets (e.g.
+~int | ~float64), or interfaces embedding comparable or a generic interface.
+These are skipped with a warning giving their position, and the rest of the
+package is mocked as normal.
+
+Function variables
+
+Exported package level variables that hold functions, such as:
+
+ var Now = time.Now
+
+are mocked in the same way as functions, and are controlled by the same
+MockAll, EnableMock and DisableMock calls:
+
+ ext.EXPECT().Now().Return(time.Date(2014, 1, 2, 3, 4, 5, 0, time.UTC))
+
+If the type of the variable isn't clear from the source (as above), then the
+recorder takes ...interface{} and the type is checked when the expectation is
+set.  Such a variable is only treated as holding a function if it is initialised
+from a function declared in the same package, or in a package that it imports,
+so variables like "var ErrNotFound = os.ErrNotExist" don't get a recorder.
+
+A variable that is nil when the package is initialised (e.g. "var OnEvent
+func(string) error") is left nil, so that code checking for nil still works,
+until an expectation is set for it.  From then on it holds a function that
+calls the mock (and panics if the real code is wanted, as there isn't any).
+
+Unexported functions
+
+Normally only exported functions and methods are mocked, but it can be useful to
+check the unexported helpers that the real code calls.  Setting "unexported" in
+the config file mocks all the unexported functions and methods of a package, or
+"unexported_functions" can be used to list just the ones wanted:
+
+ mocks:
+   example.com/ext:
+     unexported_functions: [parse, Client.fetch]
+
+Since the test code is in a different package, the recorders for these are
+reached through the Unexported method of the MOCK() object, with the first
+letter of the name in upper case.  For methods, the recorder is found by
+passing the value to a method named after the type:
+
+ ext.MOCK().Unexported().Parse("data").Return(nil)
+ ext.MOCK().Unexported().Client(c).Fetch("key").Return("value")
+
+If the name for a type is already used by the recorder for a function (e.g. a
+function called client and a type called Client), then "Type" is added to the
+name for the type, so the methods of Client would be reached through
+ClientType(c) instead.
+
+Type aliases
+
+Type aliases (including generic aliases) are kept as aliases in the mocked
+package, so values can be passed between the alias and the aliased type as
+usual.  A method declared using an alias is a method of the aliased type, so
+it is named after that type when enabling or disabling mocking:
+
+ type Client = client
+
+ func (c *Client) Name() string { ... }
+
+ ext.MOCK().EnableMock("client.Name")
+
+An alias of an interface type is mocked in the same way as the interface.
+
+Loose mocks
+
+Some packages, such as loggers or metrics clients, are used so widely that
+setting expectations for every call is more trouble than it is worth.  For
+these a mocked package can be put into loose mode, where a call to a mocked
+function that has no expectations set returns zero values instead of failing
+the test:
+
+ // Make every function in the package loose
+ logger.MOCK().LooseAll(true)
+
+ // Or just make specific functions (or methods) loose
+ logger.MOCK().EnableLoose("Logf", "Client.Flush")
+ logger.MOCK().DisableLoose("Fatalf")
+
+Calling LooseReal(true) makes loose calls fall through to the real
+implementation instead of returning zero values.  Once an expectation has been
+set for a function (or method) then calls to it are checked by the controller
+as normal.  Calls handled in loose mode are still recorded, and can be
+inspected using the Calls method:
+
+ calls := logger.MOCK().Calls("Logf")
+ if len(calls) != 2 {
+ 	t.Errorf("Expected 2 log messages, got %d", len(calls))
+ }
+
+Loose mode can also be enabled from the config file, using "loose: zero" or
+"loose: real" and optionally "loose_functions" to restrict it to a list of
+names:
+
+ mocks:
+   example.com/logger:
+     loose: zero
+     loose_functions: [Logf, Debugf]
+
+Spies
+
+Sometimes we want the real code to run, but still want to check how it was
+called.  Spying on a function (or method) calls the real implementation, even
+if mocking is enabled for it, and records the arguments and results:
+
+ // Spy on everything in the package
+ store.MOCK().SpyAll(true)
+
+ // Or just on specific functions (or methods)
+ store.MOCK().EnableSpy("Put", "Client.Get")
+ store.MOCK().DisableSpy("Get")
+
+Calls to spied functions can be checked afterwards using either Calls, or
+Called - which takes values or gomock Matchers for the arguments:
+
+ if !store.MOCK().Called("Put", "foo", gomock.Any()) {
+ 	t.Errorf("Put was not called")
+ }
+
+Calling the real code
+
+The real version of a mocked function is available from the object returned by
+the Real method of the MOCK() object, and the real version of a method can be
+called using the REAL() method of the value:
+
+ // Build a real Client, even though NewClient is mocked
+ c := ext.MOCK().Real().NewClient("fixture")
+
+ // Call the real Get method on c
+ value, err := c.REAL().Get("key")
+
+The name of the REAL() method can be changed using "obj.REAL" in the config
+file, in the same way as "obj.EXPECT".
+
+Mocking a single instance
+
+Mocking can also be enabled or disabled for a particular value, using the
+EnableMockFor and DisableMockFor methods of the MOCK() object.  With no method
+names all of the methods are affected, otherwise just the named methods:
+
+ a := ext.NewClient("a")
+ b := ext.NewClient("b")
+
+ // Only a is mocked, calls on b go to the real code
+ ext.MOCK().MockAll(false)
+ ext.MOCK().EnableMockFor(a, "Client.Get")
+
+The settings for an instance take priority over the package wide settings, and
+are cleared by MockAll.  The value must be a pointer, as instances are told
+apart by their address, and so only methods with a pointer receiver can be
+controlled this way.
+
+Tracing calls
+
+When a test fails it can be useful to see exactly which calls were made to a
+mocked package.  Calling the Trace method of the MOCK() object starts recording
+a trace of every call, with the arguments, results, the goroutine that made the
+call and whether the call was mocked or went to the real code:
+
+ ext.MOCK().Trace(t)
+
+If the test fails then the trace is logged automatically when the test ends.
+The trace can also be written out at any point using WriteTrace (text) or
+WriteTraceJSON (JSON), or examined directly using TraceCalls.  Methods of
+mocked interfaces are not traced.
+
+Recording and replaying fixtures
+
+Instead of writing out expectations by hand, the calls made to a mocked package
+can be recorded into a fixture file, and replayed later.  A test opts in by
+calling the Fixture method of the MOCK() object:
+
+ ext.MOCK().Fixture(t)
+
+and then the tests are run in record mode, where the calls go to the real code
+and the arguments and results are saved:
+
+ withmock -fixtures record go test
+
+Once the fixtures exist, running in replay mode returns the saved results
+without calling the real code at all:
+
+ withmock -fixtures replay go test
+
+The fixtures are stored as JSON under "testdata/fixtures" (this can be changed
+with -fixture-dir), with the import path of the package being tested, the name
+of the test and the import path of the mocked package making up the path of the
+file, e.g. "example.com/app/TestLookup/example.com/some/external/package.json".
+Calls are replayed in the order that they were recorded for each function or
+method, the arguments are saved only to make the files easier to read.  Errors
+are replayed using errors.New, so only the message is preserved.  Without the
+-fixtures option Fixture does nothing, and the test uses gomock as usual.
+
+Learning expectations
+
+As a starting point for writing a test, withmock can also turn an observed run
+into EXPECT statements.  In learn mode the calls go to the real code, as when
+recording, and the statements that would reproduce them are written to a
+".expect" file next to the fixtures and logged by the test:
+
+ withmock -fixtures learn go test -v
+
+which gives output like:
+
+ ext.EXPECT().NewClient("fixture").Return(&ext.Client{name:"fixture"})
+ // recv1 is &ext.Client{name:"fixture"}
+ recv1.EXPECT().Get("key").Return("value", nil)
+
+Values are written using %#v, so the output will need some editing (e.g. when
+values contain pointers or unexported fields) before it can be used.
+
+Typed recorders
+
+By default the recorder methods take interface{} arguments and return a plain
+*gomock.Call, so a Return with the wrong types is only noticed when the test
+runs (if at all).  Setting "typed_recorders" in the config file generates a
+wrapper around the call instead, where Return, Do and DoAndReturn take the real
+types of the function:
+
+ mocks:
+   example.com/ext:
+     typed_recorders: true
+
+so that:
+
+ ext.EXPECT().Lookup("key").Return("value", nil)
+
+fails to compile if Lookup doesn't return (string, error).  The arguments still
+take interface{}, so that gomock Matchers can be used.
+
+Mocking the code under test
+
+Normally only imported packages are mocked, so calls between the functions of
+the package under test always go to the real code.  Adding a "withmock:self"
+comment near the top of one of the test files (before the imports) asks for the
+package under test to be mocked as well:
+
+ // withmock:self
+
+ package code
+
+Nothing is mocked until the test asks for it, so the tests can then use the
+MOCK() and EXPECT() functions of the package itself to replace a helper, while
+still calling the real version of the function being tested:
+
+ MOCK().SetController(ctrl)
+ MOCK().EnableMock("Read")
+
+ EXPECT().Read("foo").Return("", errors.New("not found"))
+
+ if value := Load("foo"); value != "default" {
+ 	t.Errorf("Expected 'default', got '%s'", value)
+ }
+
+Mocking the imports of a dependency
+
+Marks only change the imports of the package being tested, so a dependency that
+imports a standard library package normally uses the real version of it, even
+if the test code mocks it.  Setting "mock_imports" for the dependency in the
+config file rebuilds it against the mocked version of the listed packages (or
+patterns):
+
+ mocks:
+   example.com/client:
+     mock_imports: [net/http]
+
+The test code can then control net/http as seen by example.com/client, by
+marking its own import of net/http as usual.  Packages outside the standard
+library only ever have one, mockable, version, so listing them in mock_imports
+has no extra effect.  Note that the types of the mocked package are different
+from those of the real package, so values can't be passed between the
+dependency and code that uses the real package.
+
+Only dependencies that are rebuilt by withmock can use mocked imports, so a
+warning is given if mock_imports is set for a standard library, internal,
+excluded or replaced package, or lists an internal or excluded package.
+
+Controlling mock generation
+
+Some details of how a mocked package is generated can also be set in the config
+file, either for a particular package or for every package using DEFAULT (with
+the value for a particular package taking priority):
+
+ mocks:
+   DEFAULT:
+     ignore_non_go_files: true
+   example.com/metrics/client:
+     ignore_inits: true
+
+Setting "ignore_inits" stops the init functions of the real package from being
+called, "ignore_non_go_files" stops non-Go files (including C and assembly code)
+from being copied into the mocked package, "match_os_arch" only uses the files
+that would be built for the current GOOS and GOARCH, and "mock_prototypes"
+generates stubs for functions declared without a body.  By default only
+match_os_arch is set, except for standard library packages, which have all
+four set.
+
+Config files
+
+As well as the file given using -c, withmock and mocktest look for config files
+named ".withmock.yaml", starting in the directory of the package being tested
+and walking up until they reach a directory containing go.mod or .git.  A file
+in $HOME/.withmock/config.yaml is also used if it exists.  The files are loaded
+in order from the most general to the most specific, so the global file comes
+first, then the files from the root of the repository down to the package
+directory, and finally the file given using -c.
+
+Each file is merged into the configuration loaded before it, with any values
+that it sets replacing the earlier ones, so a .withmock.yaml at the root of a
+repository can set the policy for the project, and a package can then adjust
+it:
+
+ mocks:
+   example.com/slow/database:
+     mode: mock
+     loose: zero
+
+A setting made by an earlier file can also be turned back off: "loose: off",
+"mode: none", "no_controller: fail", "typed_recorders: false" and
+"unexported: false" all restore the default behaviour, and an empty list (e.g.
+"loose_functions: []") replaces an earlier list.
+
+When mocktest is given more than one package, the config files for each of
+them are loaded in turn, with a file shared by more than one package (e.g. at
+the root of the repository) only loaded the first time.
+
+To see the combined configuration, and the files that it came from, use:
+
+ withmock config [package]
+
+where package defaults to the package in the current directory.
+
+Config files are checked when they are loaded, and any key that isn't
+understood is reported as an error (with its line number), so that a typo
+doesn't just get ignored.  The names given for MOCK, EXPECT, obj.EXPECT and
+obj.REAL must be exported Go identifiers, and must not clash with anything
+declared by the package being mocked.  A warning is printed for any package
+(or pattern) in the config that isn't imported by the code being tested (or
+isn't the package being tested), as this usually means that the path is wrong.
+Only the config file given with -c and the one in the directory of the package
+being tested are checked, as the others will normally have entries for many
+other packages.
+
 Running the tests
 
 And now we just need to wrap our call to "go test", so we run:
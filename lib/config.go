@@ -6,8 +6,14 @@ package lib
 
 import (
 	"bufio"
+	"fmt"
+	"go/ast"
+	"go/token"
+	"io"
 	"io/ioutil"
 	"os"
+	"path/filepath"
+	"sort"
 	"strings"
 
 	"gopkg.in/yaml.v2"
@@ -39,19 +45,61 @@ func readPackages(path string) ([]string, error) {
 
 type MockConfig struct {
 	// Local configuration
-	MockPrototypes   bool // Mock prototypes (i.e. functions without bodies)
-	IgnoreInits      bool // Don't call the original init functions
-	MatchOSArch      bool // only use files for GOOS & GOARCH
-	IgnoreNonGoFiles bool // Don't copy non-go files into the mocked package
+	MockPrototypes   bool `yaml:"-"` // Mock prototypes (i.e. functions without bodies)
+	IgnoreInits      bool `yaml:"-"` // Don't call the original init functions
+	MatchOSArch      bool `yaml:"-"` // only use files for GOOS & GOARCH
+	IgnoreNonGoFiles bool `yaml:"-"` // Don't copy non-go files into the mocked package
+
+	// Overrides for the local configuration from the config file, nil if not
+	// set.  These are applied by MakePkg, after the defaults for the kind of
+	// package being mocked have been set.
+	SetMockPrototypes   *bool `yaml:"mock_prototypes,omitempty"`
+	SetIgnoreInits      *bool `yaml:"ignore_inits,omitempty"`
+	SetMatchOSArch      *bool `yaml:"match_os_arch,omitempty"`
+	SetIgnoreNonGoFiles *bool `yaml:"ignore_non_go_files,omitempty"`
 
 	// File based configuration
-	MOCK      string `yaml:"MOCK"`
-	EXPECT    string `yaml:"EXPECT"`
-	ObjEXPECT string `yaml:"obj.EXPECT"`
+	MOCK      string `yaml:"MOCK,omitempty"`
+	EXPECT    string `yaml:"EXPECT,omitempty"`
+	ObjEXPECT string `yaml:"obj.EXPECT,omitempty"`
+	ObjREAL   string `yaml:"obj.REAL,omitempty"`
+
+	// Loose mocking, calls without an expectation return zero values ("zero")
+	// or call the real code ("real") instead of failing.  "off" turns loose
+	// mode (including LooseFunctions) back off, overriding an earlier file.
+	Loose          string   `yaml:"loose,omitempty"`
+	LooseFunctions []string `yaml:"loose_functions,omitempty"`
+
+	// Generate recorders where Return, Do and DoAndReturn are type checked.
+	// This (like Unexported) is nil if not set, so that a later config file
+	// can turn it off again.
+	TypedRecorders *bool `yaml:"typed_recorders,omitempty"`
+
+	// What to do if a mocked function is called before SetController, either
+	// panic with an explanation ("fail", the default) or call the real code
+	// ("real").
+	NoController string `yaml:"no_controller,omitempty"`
+
+	// Also mock unexported functions and methods, either all of them or just
+	// those listed (using "Type.method" for methods).
+	Unexported          *bool    `yaml:"unexported,omitempty"`
+	UnexportedFunctions []string `yaml:"unexported_functions,omitempty"`
+
+	// How the package is handled, whatever the import comments say: "mock"
+	// (as if marked with // mock), "replace" (with the package given by With),
+	// "exclude" (never mocked) or "link" (installed even if not imported).
+	// "none" leaves it to the import comments, overriding an earlier file.
+	Mode string `yaml:"mode,omitempty"`
+	With string `yaml:"with,omitempty"`
+
+	// Imports of this package (or patterns) that should use the mocked
+	// version of the imported package, even though this package is only a
+	// dependency of the code under test.
+	MockImports []string `yaml:"mock_imports,omitempty"`
 }
 
 type Config struct {
-	Mocks map[string]*MockConfig
+	Mocks map[string]*MockConfig `yaml:"mocks,omitempty"`
 }
 
 func (c *Config) Mock(path string) *MockConfig {
@@ -59,40 +107,217 @@ func (c *Config) Mock(path string) *MockConfig {
 		MOCK:      "MOCK",
 		EXPECT:    "EXPECT",
 		ObjEXPECT: "EXPECT",
+		ObjREAL:   "REAL",
 	}
 
-	dc, found := c.Mocks["DEFAULT"]
-	if !found {
-		dc = &MockConfig{}
+	if dc, found := c.Mocks["DEFAULT"]; found {
+		m.merge(dc)
+	}
+
+	if mc := c.lookup(path); mc != nil {
+		m.merge(mc)
+	}
+
+	if m.Loose == "off" {
+		m.Loose = ""
+		m.LooseFunctions = nil
+	}
+	if m.Mode == "none" {
+		m.Mode = ""
+	}
+
+	return m
+}
+
+// merge updates m with any values that have been set in o.
+func (m *MockConfig) merge(o *MockConfig) {
+	if o.SetMockPrototypes != nil {
+		m.SetMockPrototypes = o.SetMockPrototypes
+	}
+	if o.SetIgnoreInits != nil {
+		m.SetIgnoreInits = o.SetIgnoreInits
+	}
+	if o.SetMatchOSArch != nil {
+		m.SetMatchOSArch = o.SetMatchOSArch
+	}
+	if o.SetIgnoreNonGoFiles != nil {
+		m.SetIgnoreNonGoFiles = o.SetIgnoreNonGoFiles
+	}
+
+	if o.MOCK != "" {
+		m.MOCK = o.MOCK
+	}
+	if o.EXPECT != "" {
+		m.EXPECT = o.EXPECT
+	}
+	if o.ObjEXPECT != "" {
+		m.ObjEXPECT = o.ObjEXPECT
+	}
+	if o.ObjREAL != "" {
+		m.ObjREAL = o.ObjREAL
+	}
+
+	if o.Loose != "" {
+		m.Loose = o.Loose
+	}
+	if o.LooseFunctions != nil {
+		m.LooseFunctions = o.LooseFunctions
+	}
+
+	if o.NoController != "" {
+		m.NoController = o.NoController
+	}
+
+	if o.UnexportedFunctions != nil {
+		m.UnexportedFunctions = o.UnexportedFunctions
+	}
+
+	if o.MockImports != nil {
+		m.MockImports = o.MockImports
+	}
+
+	if o.TypedRecorders != nil {
+		m.TypedRecorders = o.TypedRecorders
+	}
+	if o.Unexported != nil {
+		m.Unexported = o.Unexported
+	}
+
+	// The mode and with go together, as with only makes sense for replace
+	if o.Mode != "" {
+		m.Mode = o.Mode
+		m.With = o.With
+	}
+}
+
+// merge adds the entries from o to c, with the values set in o taking priority
+// over those already in c.
+func (c *Config) merge(o *Config) {
+	if c.Mocks == nil {
+		c.Mocks = make(map[string]*MockConfig)
+	}
+
+	for path, mc := range o.Mocks {
+		m, found := c.Mocks[path]
+		if !found {
+			m = &MockConfig{}
+			c.Mocks[path] = m
+		}
+		m.merge(mc)
+	}
+}
+
+// applyOverrides updates the local configuration with any values that were set
+// in the config file.
+func (m *MockConfig) applyOverrides() {
+	if m.SetMockPrototypes != nil {
+		m.MockPrototypes = *m.SetMockPrototypes
 	}
+	if m.SetIgnoreInits != nil {
+		m.IgnoreInits = *m.SetIgnoreInits
+	}
+	if m.SetMatchOSArch != nil {
+		m.MatchOSArch = *m.SetMatchOSArch
+	}
+	if m.SetIgnoreNonGoFiles != nil {
+		m.IgnoreNonGoFiles = *m.SetIgnoreNonGoFiles
+	}
+}
 
-	mc, found := c.Mocks[path]
+// isSet returns true if b has been set to true.
+func isSet(b *bool) bool {
+	return b != nil && *b
+}
+
+// lookup returns the entry in the config file for path, which is either the
+// entry for path itself, or the best matching pattern (see bestMatch).  nil is
+// returned if there is no matching entry.
+func (c *Config) lookup(path string) *MockConfig {
+	pats := make([]string, 0, len(c.Mocks))
+	for pat := range c.Mocks {
+		if pat != "DEFAULT" {
+			pats = append(pats, pat)
+		}
+	}
+
+	pat, found := bestMatch(path, pats)
 	if !found {
-		mc = &MockConfig{}
+		return nil
 	}
 
-	switch {
-	case mc.MOCK != "":
-		m.MOCK = mc.MOCK
-	case dc.MOCK != "":
-		m.MOCK = dc.MOCK
+	return c.Mocks[pat]
+}
+
+// isMocked returns true if the config file says that path should be mocked.
+func (c *Config) isMocked(path string) bool {
+	mc := c.lookup(path)
+	return mc != nil && mc.Mode == "mock"
+}
+
+// applyModes updates imports with the modes set in the config file for any of
+// the packages that it contains.
+func (c *Config) applyModes(imports importSet) error {
+	for path := range imports {
+		mc := c.lookup(path)
+		if mc == nil {
+			continue
+		}
+
+		var err error
+		switch mc.Mode {
+		case "mock":
+			err = imports.Set(path, importMock, "")
+		case "replace":
+			err = imports.Set(path, importReplace, mc.With)
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %s", path, err)
+		}
 	}
 
-	switch {
-	case mc.EXPECT != "":
-		m.EXPECT = mc.EXPECT
-	case dc.EXPECT != "":
-		m.EXPECT = dc.EXPECT
+	return nil
+}
+
+// packages returns the packages (or patterns) that have the given mode in the
+// config file.
+func (c *Config) packages(mode string) []string {
+	pkgs := []string{}
+	for path, mc := range c.Mocks {
+		if mc.Mode == mode {
+			pkgs = append(pkgs, path)
+		}
 	}
+	return pkgs
+}
+
+// unused returns the packages (or patterns) in the config that don't match any
+// of the packages in seen.  DEFAULT, and packages with a mode of link (which
+// are installed whether imported or not), are never included.
+func (c *Config) unused(seen map[string]bool) []string {
+	pkgs := []string{}
+
+	for path, mc := range c.Mocks {
+		if path == "DEFAULT" || mc.Mode == "link" {
+			continue
+		}
+
+		used := seen[path]
+		if isPattern(path) {
+			for pkg := range seen {
+				if matchPattern(path, pkg) {
+					used = true
+					break
+				}
+			}
+		}
 
-	switch {
-	case mc.ObjEXPECT != "":
-		m.ObjEXPECT = mc.ObjEXPECT
-	case dc.ObjEXPECT != "":
-		m.ObjEXPECT = dc.ObjEXPECT
+		if !used {
+			pkgs = append(pkgs, path)
+		}
 	}
 
-	return m
+	sort.Strings(pkgs)
+	return pkgs
 }
 
 func ReadConfig(path string) (*Config, error) {
@@ -109,10 +334,136 @@ func ReadConfig(path string) (*Config, error) {
 
 	cfg := &Config{}
 
-	err = yaml.Unmarshal(data, cfg)
+	// Decode strictly, so that a mistyped key is reported (with its line
+	// number) rather than silently ignored.
+	err = yaml.UnmarshalStrict(data, cfg)
+	if te, ok := err.(*yaml.TypeError); ok {
+		return nil, fmt.Errorf("%s: %s", path, strings.Join(te.Errors, "; "))
+	}
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+
+	for pkg, mc := range cfg.Mocks {
+		if mc == nil {
+			return nil, fmt.Errorf("%s: no settings given for %s", path, pkg)
+		}
+		names := []struct{ key, value string }{
+			{"MOCK", mc.MOCK},
+			{"EXPECT", mc.EXPECT},
+			{"obj.EXPECT", mc.ObjEXPECT},
+			{"obj.REAL", mc.ObjREAL},
+		}
+		for _, n := range names {
+			if n.value != "" && !validName(n.value) {
+				return nil, fmt.Errorf("%s: invalid %s name '%s' for %s "+
+					"(expected an exported Go identifier)", path, n.key,
+					n.value, pkg)
+			}
+		}
+		switch mc.Loose {
+		case "", "zero", "real", "off":
+		default:
+			return nil, fmt.Errorf("%s: invalid loose mode '%s' for %s "+
+				"(expected zero, real or off)", path, mc.Loose, pkg)
+		}
+		switch mc.NoController {
+		case "", "fail", "real":
+		default:
+			return nil, fmt.Errorf("%s: invalid no_controller mode '%s' for "+
+				"%s (expected fail or real)", path, mc.NoController, pkg)
+		}
+		switch mc.Mode {
+		case "", "none", "mock", "exclude", "link":
+			if mc.With != "" {
+				return nil, fmt.Errorf("%s: with is only valid for mode "+
+					"replace (%s)", path, pkg)
+			}
+		case "replace":
+			if mc.With == "" {
+				return nil, fmt.Errorf("%s: mode replace needs with to "+
+					"be set (%s)", path, pkg)
+			}
+			if isPattern(pkg) {
+				return nil, fmt.Errorf("%s: mode replace can't be used with "+
+					"a pattern (%s)", path, pkg)
+			}
+		default:
+			return nil, fmt.Errorf("%s: invalid mode '%s' for %s (expected "+
+				"mock, replace, exclude, link or none)", path, mc.Mode, pkg)
+		}
+		if pkg == "DEFAULT" && mc.Mode != "" {
+			return nil, fmt.Errorf("%s: mode can't be set for DEFAULT", path)
+		}
 	}
 
 	return cfg, nil
 }
+
+// validName returns true if name can be used as the name of a generated
+// function, which means it must be an exported Go identifier.
+func validName(name string) bool {
+	return token.IsIdentifier(name) && ast.IsExported(name)
+}
+
+// configName is the name of the config files that FindConfigs looks for.
+const configName = ".withmock.yaml"
+
+// FindConfigs returns the config files that apply to the package in dir, in
+// the order that they should be loaded.  The user's global config file
+// ($HOME/.withmock/config.yaml) comes first, followed by any .withmock.yaml
+// files found walking up from dir to the root of the module or repository,
+// with the file nearest the root first.
+func FindConfigs(dir string) ([]string, error) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, Cerr{"filepath.Abs", err}
+	}
+
+	found := []string{}
+
+	for {
+		path := filepath.Join(dir, configName)
+		if exists(path) {
+			found = append(found, path)
+		}
+
+		if exists(filepath.Join(dir, "go.mod")) ||
+			exists(filepath.Join(dir, ".git")) {
+			// We have reached the root of the module or repository
+			break
+		}
+
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			break
+		}
+		dir = parent
+	}
+
+	paths := []string{}
+
+	if home := os.Getenv("HOME"); home != "" {
+		path := filepath.Join(home, ".withmock", "config.yaml")
+		if exists(path) {
+			paths = append(paths, path)
+		}
+	}
+
+	for i := len(found) - 1; i >= 0; i-- {
+		paths = append(paths, found[i])
+	}
+
+	return paths, nil
+}
+
+// Write writes c out as YAML.
+func (c *Config) Write(w io.Writer) error {
+	data, err := yaml.Marshal(c)
+	if err != nil {
+		return err
+	}
+
+	_, err = w.Write(data)
+	return err
+}
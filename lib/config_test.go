@@ -0,0 +1,262 @@
+// Copyright 2013 Julian Phillips.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package lib
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func writeFile(t *testing.T, path, content string) {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		t.Fatalf("Failed to create directory: %s", err)
+	}
+	if err := ioutil.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatalf("Failed to write %s: %s", path, err)
+	}
+}
+
+func TestFindConfigs(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "withmock-TestFindConfigs")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %s", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	// Resolve any symlinks, as FindConfigs returns absolute paths
+	tmpDir, err = filepath.EvalSymlinks(tmpDir)
+	if err != nil {
+		t.Fatalf("Failed to resolve temp directory: %s", err)
+	}
+
+	path := func(elem ...string) string {
+		return filepath.Join(append([]string{tmpDir}, elem...)...)
+	}
+
+	home := path("home", ".withmock", "config.yaml")
+
+	for _, file := range []string{
+		home,
+		path(configName),
+		path("repo", ".git", "HEAD"),
+		path("repo", configName),
+		path("repo", "a", configName),
+		path("repo", "a", "b", "b.go"),
+		path("mod", "go.mod"),
+		path("mod", "x", configName),
+		path("mod", "x", "y", configName),
+		path("other", "c", "c.go"),
+	} {
+		writeFile(t, file, "")
+	}
+
+	oldHome := os.Getenv("HOME")
+	defer os.Setenv("HOME", oldHome)
+
+	tests := []struct {
+		home  string
+		dir   string
+		found []string
+	}{
+		// Stop at the .git directory, and put the nearest file last
+		{path("home"), path("repo", "a", "b"),
+			[]string{home, path("repo", configName),
+				path("repo", "a", configName)}},
+		// Stop at the go.mod, even though it has no config file
+		{path("home"), path("mod", "x", "y"),
+			[]string{home, path("mod", "x", configName),
+				path("mod", "x", "y", configName)}},
+		// Without a module or repository, carry on to the root
+		{path("home"), path("other", "c"),
+			[]string{home, path(configName)}},
+		// No global config file
+		{path("nohome"), path("repo", "a"),
+			[]string{path("repo", configName),
+				path("repo", "a", configName)}},
+	}
+
+	for _, test := range tests {
+		os.Setenv("HOME", test.home)
+
+		found, err := FindConfigs(test.dir)
+		if err != nil {
+			t.Errorf("FindConfigs(%q): unexpected error: %s", test.dir, err)
+			continue
+		}
+
+		// Ignore anything found above the temp directory
+		paths := []string{}
+		for _, p := range found {
+			if strings.HasPrefix(p, tmpDir+string(filepath.Separator)) {
+				paths = append(paths, p)
+			}
+		}
+
+		if !reflect.DeepEqual(paths, test.found) {
+			t.Errorf("FindConfigs(%q): expected %q, got %q", test.dir,
+				test.found, paths)
+		}
+	}
+}
+
+func TestConfigOverride(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "withmock-TestConfigOverride")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %s", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	files := []string{
+		`mocks:
+  DEFAULT:
+    EXPECT: EXP
+    loose: zero
+    typed_recorders: true
+  example.com/sdk/...:
+    typed_recorders: true
+    unexported: true
+    no_controller: real
+  example.com/sdk/tool:
+    mode: mock
+    loose: real
+    loose_functions: [Get]
+    no_controller: real
+`,
+		`mocks:
+  example.com/sdk/...:
+    loose: real
+  example.com/sdk/client:
+    typed_recorders: false
+`,
+		`mocks:
+  example.com/sdk/...:
+    unexported: false
+  example.com/sdk/client:
+    EXPECT: Expect
+  example.com/sdk/tool:
+    mode: none
+    loose: off
+    loose_functions: []
+    no_controller: fail
+`,
+	}
+
+	cfg := &Config{}
+	for i, content := range files {
+		path := filepath.Join(tmpDir, fmt.Sprintf("%d.yaml", i))
+		writeFile(t, path, content)
+
+		c, err := ReadConfig(path)
+		if err != nil {
+			t.Fatalf("ReadConfig(%s): %s", path, err)
+		}
+		cfg.merge(c)
+	}
+
+	tests := []struct {
+		path                       string
+		expect, loose, noCtrl      string
+		typedRecorders, unexported bool
+		mode                       string
+	}{
+		{"example.com/other", "EXP", "zero", "", true, false, ""},
+		{"example.com/sdk/server", "EXP", "real", "real", true, false, ""},
+		{"example.com/sdk/client", "Expect", "zero", "", false, false, ""},
+		// Loose and mode turned off again by a later file
+		{"example.com/sdk/tool", "EXP", "", "fail", true, false, ""},
+	}
+
+	for _, test := range tests {
+		mc := cfg.Mock(test.path)
+
+		if mc.EXPECT != test.expect {
+			t.Errorf("%s: expected EXPECT %q, got %q", test.path,
+				test.expect, mc.EXPECT)
+		}
+		if mc.Loose != test.loose {
+			t.Errorf("%s: expected loose %q, got %q", test.path, test.loose,
+				mc.Loose)
+		}
+		if mc.NoController != test.noCtrl {
+			t.Errorf("%s: expected no_controller %q, got %q", test.path,
+				test.noCtrl, mc.NoController)
+		}
+		if isSet(mc.TypedRecorders) != test.typedRecorders {
+			t.Errorf("%s: expected typed_recorders %v, got %v", test.path,
+				test.typedRecorders, isSet(mc.TypedRecorders))
+		}
+		if isSet(mc.Unexported) != test.unexported {
+			t.Errorf("%s: expected unexported %v, got %v", test.path,
+				test.unexported, isSet(mc.Unexported))
+		}
+		if mc.Mode != test.mode {
+			t.Errorf("%s: expected mode %q, got %q", test.path, test.mode,
+				mc.Mode)
+		}
+		if len(mc.LooseFunctions) != 0 {
+			t.Errorf("%s: expected no loose_functions, got %q", test.path,
+				mc.LooseFunctions)
+		}
+		if cfg.isMocked(test.path) {
+			t.Errorf("%s: expected not to be mocked", test.path)
+		}
+	}
+}
+
+func TestLoadConfigsShared(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "withmock-TestLoadConfigsShared")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %s", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	tmpDir, err = filepath.EvalSymlinks(tmpDir)
+	if err != nil {
+		t.Fatalf("Failed to resolve temp directory: %s", err)
+	}
+
+	oldHome := os.Getenv("HOME")
+	defer os.Setenv("HOME", oldHome)
+	os.Setenv("HOME", filepath.Join(tmpDir, "nohome"))
+
+	root := filepath.Join(tmpDir, "repo", configName)
+	a := filepath.Join(tmpDir, "repo", "a", configName)
+
+	writeFile(t, filepath.Join(tmpDir, "repo", "go.mod"), "")
+	writeFile(t, root, "mocks:\n  example.com/ext:\n    loose: zero\n")
+	writeFile(t, a, "mocks:\n  example.com/ext:\n    loose: real\n")
+	writeFile(t, filepath.Join(tmpDir, "repo", "b", "b.go"), "")
+
+	c := &Context{
+		cfg:      &Config{},
+		cfgCheck: make(map[string]string),
+	}
+
+	// Loading the configs for b must not load the root file again, as that
+	// would undo the setting from a's file.
+	for _, pkg := range []string{"a", "b"} {
+		if err := c.LoadConfigs(filepath.Join(tmpDir, "repo", pkg)); err != nil {
+			t.Fatalf("LoadConfigs(%s): unexpected error: %s", pkg, err)
+		}
+	}
+
+	if expected := []string{root, a}; !reflect.DeepEqual(c.cfgFiles, expected) {
+		t.Errorf("expected files %q, got %q", expected, c.cfgFiles)
+	}
+
+	if loose := c.cfg.Mock("example.com/ext").Loose; loose != "real" {
+		t.Errorf("expected loose %q, got %q", "real", loose)
+	}
+
+	if c.cfgCheck["example.com/ext"] != a {
+		t.Errorf("expected example.com/ext to be checked from %s, got %q", a,
+			c.cfgCheck["example.com/ext"])
+	}
+}
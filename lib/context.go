@@ -6,11 +6,13 @@ package lib
 
 import (
 	"fmt"
+	"io"
 	"io/ioutil"
 	"log"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"sort"
 	"strings"
 )
 
@@ -29,16 +31,34 @@ type Context struct {
 
 	excludes map[string]bool
 
-	processed      map[string]bool
-	importRewrites map[string]string
+	processed map[string]bool
 
+	// every package seen in the import graph, used to find config entries
+	// that don't apply to anything
+	seen map[string]bool
+
+	// the original import path for each label (see markImport), used to
+	// lookup the original mark
 	marked map[string]string
 
+	// packages with mock_imports set in the config that can't be rebuilt
+	// against the mocked imports, with the reason why, reported by
+	// WarnUnusedConfig
+	ignoredMockImports map[string]string
+
 	doRewrite bool
 
+	fixtureMode, fixtureDir string
+
 	code []codeLoc
 
-	cfg *Config
+	cfg      *Config
+	cfgFiles []string
+
+	// the file that each entry to be checked by WarnUnusedConfig came from,
+	// only the entries from the package's own config file and the one given
+	// on the command line are checked
+	cfgCheck map[string]string
 
 	cache    *Cache
 	packages map[string]Package
@@ -84,26 +104,28 @@ func NewContext() (*Context, error) {
 	// Build and return the context
 
 	return &Context{
-		goPath:         goPath,
-		goRoot:         goRoot,
-		origPath:       os.Getenv("GOPATH"),
-		tmpPath:        getTmpPath(tmpDir),
-		tmpDir:         tmpDir,
-		stdlibImports:  stdlibImports,
-		removeTmp:      true,
-		processed:      make(map[string]bool),
-		importRewrites: make(map[string]string),
-		marked:         make(map[string]string),
-		doRewrite:      true,
-		cfg:            &Config{},
-		cache:          cache,
-		packages:       make(map[string]Package),
+		goPath:        goPath,
+		goRoot:        goRoot,
+		origPath:      os.Getenv("GOPATH"),
+		tmpPath:       getTmpPath(tmpDir),
+		tmpDir:        tmpDir,
+		stdlibImports: stdlibImports,
+		removeTmp:     true,
+		processed:     make(map[string]bool),
+		seen:          make(map[string]bool),
+		cfgCheck:      make(map[string]string),
+		marked:        make(map[string]string),
+		doRewrite:     true,
+		cfg:           &Config{},
+		cache:         cache,
+		packages:      make(map[string]Package),
 		// create excludes already including gomock and its deps, as we can't
 		// mock them.
 		excludes: map[string]bool{
 			"github.com/golang/mock/gomock": true,
 			"golang.org/x/net/context":      true,
 		},
+		ignoredMockImports: make(map[string]string),
 	}, nil
 }
 
@@ -118,6 +140,25 @@ func (c *Context) DisableRewrite() {
 	c.doRewrite = false
 }
 
+// SetFixtures configures the mocked packages to record calls to, or replay
+// calls from, fixture files stored under dir.  In learn mode the calls are
+// written out as EXPECT statements instead.
+func (c *Context) SetFixtures(mode, dir string) error {
+	if mode != "record" && mode != "replay" && mode != "learn" {
+		return fmt.Errorf("invalid fixture mode '%s' (expected record, replay or learn)", mode)
+	}
+
+	absDir, err := filepath.Abs(dir)
+	if err != nil {
+		return Cerr{"filepath.Abs", err}
+	}
+
+	c.fixtureMode = mode
+	c.fixtureDir = absDir
+
+	return nil
+}
+
 func (c *Context) Close() error {
 	if c.removeTmp {
 		if err := os.RemoveAll(c.tmpDir); err != nil {
@@ -132,9 +173,81 @@ func (c *Context) Close() error {
 	return nil
 }
 
-func (c *Context) LoadConfig(path string) (err error) {
-	c.cfg, err = ReadConfig(path)
-	return
+// LoadConfig loads the config file at path, adding it to the configuration
+// that has already been loaded (with the values from path taking priority).
+func (c *Context) LoadConfig(path string) error {
+	return c.loadConfig(path, true)
+}
+
+// loadConfig loads the config file at path, if check is true then the entries
+// in the file will be checked by WarnUnusedConfig.
+func (c *Context) loadConfig(path string, check bool) error {
+	cfg, err := ReadConfig(path)
+	if err != nil {
+		return err
+	}
+
+	c.cfg.merge(cfg)
+	c.cfgFiles = append(c.cfgFiles, path)
+
+	if check {
+		for pkg := range cfg.Mocks {
+			c.cfgCheck[pkg] = path
+		}
+	}
+
+	return nil
+}
+
+// LoadConfigs loads the config files found by FindConfigs for the package in
+// dir.  Only the entries in the file in dir itself are checked by
+// WarnUnusedConfig, as the other files will usually have entries for other
+// packages.  When testing more than one package, LoadConfigs should be called
+// for each of them in turn - files that have already been loaded (e.g. the one
+// at the root of the repository) are skipped, so that they don't override the
+// more specific files loaded since.
+func (c *Context) LoadConfigs(dir string) error {
+	paths, err := FindConfigs(dir)
+	if err != nil {
+		return Cerr{"FindConfigs", err}
+	}
+
+	absDir, err := filepath.Abs(dir)
+	if err != nil {
+		return Cerr{"filepath.Abs", err}
+	}
+
+	for _, path := range paths {
+		if c.configLoaded(path) {
+			continue
+		}
+		if err := c.loadConfig(path, filepath.Dir(path) == absDir); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// configLoaded returns true if the config file at path has already been
+// loaded.
+func (c *Context) configLoaded(path string) bool {
+	for _, loaded := range c.cfgFiles {
+		if loaded == path {
+			return true
+		}
+	}
+	return false
+}
+
+// WriteConfig writes out the configuration that has been loaded, listing the
+// files that it came from first.
+func (c *Context) WriteConfig(w io.Writer) error {
+	fmt.Fprintf(w, "# loaded from:\n")
+	for _, path := range c.cfgFiles {
+		fmt.Fprintf(w, "#   %s\n", path)
+	}
+	return c.cfg.Write(w)
 }
 
 func (c *Context) insideCommand(command string, args ...string) *exec.Cmd {
@@ -151,6 +264,11 @@ func (c *Context) insideCommand(command string, args ...string) *exec.Cmd {
 	env = append(env, "GOPATH="+c.tmpPath)
 	env = append(env, "ORIG_GOPATH="+c.origPath)
 
+	if c.fixtureMode != "" {
+		env = append(env, "WITHMOCK_FIXTURES="+c.fixtureMode)
+		env = append(env, "WITHMOCK_FIXTURE_DIR="+c.fixtureDir)
+	}
+
 	cmd := exec.Command(command, args...)
 	cmd.Env = env
 	return cmd
@@ -233,6 +351,7 @@ func (c *Context) wantToProcess(mockAllowed bool, imports importSet) map[string]
 		}
 		names[name] = label
 
+		c.seen[name] = true
 		c.processed[label] = c.processed[label] || false
 
 		if strings.HasSuffix(label, "/_mocks_") {
@@ -302,6 +421,7 @@ func (c *Context) installImports(imports importSet) (map[string]string, error) {
 			if imports[name].IsReplace() {
 				// Install the requested package in place of the
 				// package that the code thinks it wants.
+				c.ignoreMockImports(name, "is replaced")
 				srcPath := imports[name].path
 				pkgImports, err := ReplacePkg(c.goPath, c.tmpPath, srcPath, label)
 				if err != nil {
@@ -317,6 +437,7 @@ func (c *Context) installImports(imports importSet) (map[string]string, error) {
 
 			if c.stdlibImports[name] && !mock {
 				// Ignore standard packages that we aren't mocking
+				c.ignoreMockImports(name, "is in the standard library")
 				continue
 			}
 
@@ -333,6 +454,7 @@ func (c *Context) installImports(imports importSet) (map[string]string, error) {
 
 			if internalPkg(name) {
 				// If the package is an internal package, then we just link it.
+				c.ignoreMockImports(name, "is an internal package")
 				pkgImports, err := pkg.Link()
 				if err != nil {
 					return nil, Cerr{"pkg.Link", err}
@@ -345,9 +467,10 @@ func (c *Context) installImports(imports importSet) (map[string]string, error) {
 				continue
 			}
 
-			if c.excludes[name] {
+			if c.excluded(name) {
 				// this package has been specifically excluded from mocking, so
 				// we just link it, even if mocked is indicated.
+				c.ignoreMockImports(name, "is excluded")
 				pkgImports, err := pkg.Link()
 				if err != nil {
 					return nil, Cerr{"pkg.Link", err}
@@ -363,6 +486,7 @@ func (c *Context) installImports(imports importSet) (map[string]string, error) {
 			if c.stdlibImports[name] {
 				// We already checked earlier for unmocked stdlib, so
 				// this is mocked stdlib
+				c.ignoreMockImports(name, "is in the standard library")
 				err := MockStandard(c.goRoot, c.tmpPath, name, cfg)
 				if err != nil {
 					return nil, Cerr{"MockStandard", err}
@@ -376,6 +500,13 @@ func (c *Context) installImports(imports importSet) (map[string]string, error) {
 				return nil, Cerr{"GenPkg", err}
 			}
 
+			if len(cfg.MockImports) > 0 {
+				err := c.mockDepImports(name, pkg, pkgImports, cfg.MockImports)
+				if err != nil {
+					return nil, Cerr{"mockDepImports", err}
+				}
+			}
+
 			log.Printf("process deps")
 
 			// Update imports from the package we just processed, but it can
@@ -398,6 +529,51 @@ func (c *Context) installImports(imports importSet) (map[string]string, error) {
 	return names, nil
 }
 
+// mockDepImports rewrites the imports of pkg (a dependency of the code under
+// test) that match the mock_imports set for it in the config, so that pkg uses
+// the mocked version of those packages.
+func (c *Context) mockDepImports(name string, pkg Package, pkgImports importSet, pats []string) error {
+	mocks := make(importSet)
+
+	for _, pat := range pats {
+		found := false
+		for path := range pkgImports {
+			if !matchPattern(pat, path) {
+				continue
+			}
+			found = true
+			if internalPkg(path) || c.excluded(path) {
+				warnf("%s is in mock_imports for %s, but can't be mocked", path,
+					name)
+				continue
+			}
+			mocks.Set(path, importMock, "")
+		}
+		if !found {
+			warnf("%s is in mock_imports for %s, but isn't imported by it", pat,
+				name)
+		}
+	}
+
+	// Make sure that the mocked packages get installed, which also gives us
+	// the labels to rewrite the imports with.  Packages outside the standard
+	// library are always mocked in place, so they don't need rewriting.
+	names := c.wantToProcess(true, mocks)
+	if len(names) == 0 {
+		return nil
+	}
+
+	return pkg.RewriteImports(names, c.cfg)
+}
+
+// ignoreMockImports records that name can't be rebuilt against mocked imports
+// (because of reason), if it has mock_imports set in its entry in the config.
+func (c *Context) ignoreMockImports(name, reason string) {
+	if mc := c.cfg.lookup(name); mc != nil && len(mc.MockImports) > 0 {
+		c.ignoredMockImports[name] = reason
+	}
+}
+
 func (c *Context) getPkg(pkgName, label string) (Package, error) {
 	pkg, found := c.packages[label]
 	if found {
@@ -426,29 +602,105 @@ func (c *Context) LinkPackage(pkg string) error {
 	return err
 }
 
+// linkPackages links each of pkgs, which may include patterns.
+func (c *Context) linkPackages(pkgs []string) error {
+	for _, pkg := range pkgs {
+		if !isPattern(pkg) {
+			if err := c.LinkPackage(pkg); err != nil {
+				return err
+			}
+			continue
+		}
+
+		matches, err := expandPattern(pkg)
+		if err != nil {
+			return Cerr{"expandPattern", err}
+		}
+
+		for _, match := range matches {
+			if err := c.LinkPackage(match); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// excluded returns true if name matches any of the excluded packages (or
+// patterns).
+func (c *Context) excluded(name string) bool {
+	if c.excludes[name] {
+		return true
+	}
+
+	if mc := c.cfg.lookup(name); mc != nil && mc.Mode == "exclude" {
+		return true
+	}
+
+	for pat := range c.excludes {
+		if isPattern(pat) && matchPattern(pat, name) {
+			return true
+		}
+	}
+
+	return false
+}
+
 func (c *Context) AddPackage(pkgName string) (string, error) {
 	pkg, err := c.getPkg(pkgName, markImport(pkgName, testMark))
 	if err != nil {
 		return "", Cerr{"context.getPkg", err}
 	}
 
-	imports, err := pkg.GetImports()
+	// The directives are needed to get the imports, and then again to rewrite
+	// each of the test files, so read them just the once.
+	d, err := pkg.Directives()
+	if err != nil {
+		return "", Cerr{"pkg.Directives", err}
+	}
+
+	imports, err := pkg.GetImports(d)
 	if err != nil {
 		return "", Cerr{"pkg.GetImports", err}
 	}
 
+	if err := c.cfg.applyModes(imports); err != nil {
+		return "", Cerr{"applyModes", err}
+	}
+
+	// A "// withmock:self" directive asks for the package under test to be
+	// mocked itself
+	self := d.self
+
+	if self {
+		// The mock version of the code under test needs gomock, even if the
+		// test code doesn't import it.
+		imports.Set("github.com/golang/mock/gomock", importNormal, "")
+	}
+
 	importNames, err := c.installImports(imports)
 	if err != nil {
 		return "", Cerr{"installImports", err}
 	}
 
+	// The config can have an entry for the package being tested (e.g. for
+	// withmock:self), which shouldn't be reported as unused.
+	c.seen[pkgName] = true
+
 	newName := pkg.Label()
-	c.importRewrites[newName] = pkgName
 	importNames[pkgName] = newName
 
-	err = pkg.MockImports(importNames, c.cfg)
-	if err != nil {
-		return "", Cerr{"MockImports", err}
+	if self {
+		err = pkg.MockSelf(importNames, d, c.cfg)
+		if err != nil {
+			return "", Cerr{"MockSelf", err}
+		}
+	} else {
+		err = pkg.MockImports(importNames, d, c.cfg)
+		if err != nil {
+			return "", Cerr{"MockImports", err}
+		}
 	}
 
 	cfg := c.cfg.Mock(pkgName)
@@ -469,13 +721,39 @@ func (c *Context) LinkPackagesFromFile(path string) error {
 		return err
 	}
 
-	for _, pkg := range pkgs {
-		if err := c.LinkPackage(pkg); err != nil {
-			return err
+	return c.linkPackages(pkgs)
+}
+
+// LinkPackagesFromConfig links the packages with a mode of link in the config
+// file, in the same way as LinkPackagesFromFile.
+func (c *Context) LinkPackagesFromConfig() error {
+	return c.linkPackages(c.cfg.packages("link"))
+}
+
+// WarnUnusedConfig prints a warning for each package (or pattern) in the config
+// that doesn't match any package seen in the import graph (including the
+// packages being tested).  Only entries from the config file given on the
+// command line, or the one in the directory of the package being tested, are
+// checked.  A warning is also printed for each package with mock_imports set
+// that has no effect, as the package itself is never rebuilt (e.g. it is in the
+// standard library, or is excluded).
+func (c *Context) WarnUnusedConfig() {
+	for _, path := range c.cfg.unused(c.seen) {
+		if file, found := c.cfgCheck[path]; found {
+			warnf("%s: %s is in the config, but was never imported", file, path)
 		}
 	}
 
-	return nil
+	names := make([]string, 0, len(c.ignoredMockImports))
+	for name := range c.ignoredMockImports {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		warnf("mock_imports is set for %s, but has no effect as it %s", name,
+			c.ignoredMockImports[name])
+	}
 }
 
 func (c *Context) ExcludePackagesFromFile(path string) error {
@@ -518,10 +796,8 @@ func (c *Context) Run(command string, args ...string) error {
 			stderr.Rewrite(loc.dst, loc.src)
 		}
 
-		for marked, orig := range c.importRewrites {
-			stdout.Rewrite(marked, orig)
-			stderr.Rewrite(marked, orig)
-		}
+		stdout.RewriteLabels()
+		stderr.RewriteLabels()
 
 		cmd.Stdout = stdout
 		cmd.Stderr = stderr
@@ -7,6 +7,7 @@ package lib
 import (
 	"fmt"
 	"go/ast"
+	"go/token"
 	"os"
 )
 
@@ -18,6 +19,8 @@ type ifDetails struct {
 	methods   []*funcInfo
 	locals    []string
 	externals []external
+	alias     bool
+	pos       token.Position
 }
 
 func (id *ifDetails) addMethod(name string, f *ast.FuncType) []string {
@@ -73,6 +76,7 @@ type ifInfo struct {
 	filename string
 	types    map[string]*ifDetails
 	imports  map[string]string
+	skipped  []string
 	EXPECT   string
 }
 
@@ -80,44 +84,102 @@ func (ii *ifInfo) addImport(name, path string) {
 	ii.imports[name] = path
 }
 
-func (ii *ifInfo) addType(t *ast.TypeSpec, imports map[string]string) {
+// skipType records that the interface name won't be mocked, and why.  The
+// reason is only reported if the mocks for this package are actually written.
+func (ii *ifInfo) skipType(fset *token.FileSet, pos token.Pos, name, format string, args ...interface{}) {
+	ii.skipped = append(ii.skipped, fmt.Sprintf("%s: not mocking interface %s: %s",
+		fset.Position(pos), name, fmt.Sprintf(format, args...)))
+}
+
+func (ii *ifInfo) addType(fset *token.FileSet, t *ast.TypeSpec, imports map[string]string) {
+	if t.TypeParams != nil {
+		// Generic types can't be mocked without being instantiated
+		return
+	}
+
+	if t.Assign.IsValid() {
+		switch v := t.Type.(type) {
+		case *ast.Ident:
+			id := &ifDetails{alias: true, pos: fset.Position(t.Pos())}
+			id.addLocal(v.String())
+			ii.types[t.Name.String()] = id
+			return
+		case *ast.SelectorExpr:
+			p, ok := v.X.(*ast.Ident)
+			if !ok {
+				return
+			}
+			impPath, ok := imports[p.String()]
+			if !ok {
+				ii.skipType(fset, t.Pos(), t.Name.String(), "unknown package %s", p)
+				return
+			}
+			id := &ifDetails{alias: true, pos: fset.Position(t.Pos())}
+			id.addExternal(p.String(), impPath, v.Sel.String())
+			ii.addImport(p.String(), impPath)
+			ii.types[t.Name.String()] = id
+			return
+		}
+	}
+
 	i, ok := t.Type.(*ast.InterfaceType)
 	if !ok {
 		// Only care about interfaces
 		return
 	}
 
-	id := &ifDetails{}
+	id := &ifDetails{pos: fset.Position(t.Pos())}
 
 	for _, f := range i.Methods.List {
 		switch v := f.Type.(type) {
 		case *ast.FuncType:
+			if len(f.Names) == 0 {
+				ii.skipType(fset, f.Pos(), t.Name.String(),
+					"contains a type set, so can only be used as a constraint")
+				return
+			}
 			scopes := id.addMethod(f.Names[0].Name, v)
 			for _, scope := range scopes {
 				impPath, ok := imports[scope]
 				if !ok {
-					panic(fmt.Sprintf("Unkown package %s in interface %s",
-						scope, t.Name))
+					ii.skipType(fset, f.Pos(), t.Name.String(),
+						"unknown package %s", scope)
+					return
 				}
 				ii.addImport(scope, impPath)
 			}
 		case *ast.Ident:
+			if v.Name == "comparable" {
+				ii.skipType(fset, f.Pos(), t.Name.String(),
+					"embeds comparable, so can only be used as a constraint")
+				return
+			}
 			id.addLocal(v.String())
 		case *ast.SelectorExpr:
 			p, ok := v.X.(*ast.Ident)
 			if !ok {
-				panic(fmt.Sprintf("Don't know how to handle selector of non"+
-					" Ident value: %T", v.X))
+				ii.skipType(fset, f.Pos(), t.Name.String(),
+					"don't know how to handle selector of %T", v.X)
+				return
 			}
 			impPath, ok := imports[p.String()]
 			if !ok {
-				panic(fmt.Sprintf("Unkown package %s in interface %s",
-					p, t.Name))
+				ii.skipType(fset, f.Pos(), t.Name.String(),
+					"unknown package %s", p)
+				return
 			}
 			ii.addImport(p.String(), impPath)
 			id.addExternal(p.String(), impPath, v.Sel.String())
+		case *ast.IndexExpr, *ast.IndexListExpr:
+			ii.skipType(fset, f.Pos(), t.Name.String(),
+				"embedded generic interfaces are not supported")
+			return
 		default:
-			panic(fmt.Sprintf("Don't expect %T in interface", f.Type))
+			// Anything else is a type set element (e.g. ~int | string), so
+			// the interface can only be used as a constraint
+			ii.skipType(fset, f.Pos(), t.Name.String(),
+				"contains a type set, so can only be used as a constraint")
+			return
 		}
 	}
 
@@ -139,11 +201,18 @@ func (i Interfaces) getMethods(name string, tname string) ([]*funcInfo, error) {
 
 	methods := []*funcInfo{}
 
-	t := info.types[tname]
+	t, ok := info.types[tname]
+	if !ok {
+		return nil, fmt.Errorf("Unknown type %s in package %s", tname, name)
+	}
 
 	methods = append(methods, t.methods...)
 
 	for _, n := range t.locals {
+		// Embedding any (the empty interface) adds nothing
+		if n == "any" {
+			continue
+		}
 		// Special case for error, which is a builtin interface type
 		if n == "error" {
 			methods = append(methods, &funcInfo{
@@ -194,6 +263,10 @@ func (i Interfaces) genInterface(name string) error {
 	}
 	defer out.Close()
 
+	for _, msg := range info.skipped {
+		warnf("%s", msg)
+	}
+
 	fmt.Fprintf(out, "package %s\n\n", name)
 	fmt.Fprintf(out, "import (\n")
 	for name, impPath := range info.imports {
@@ -201,7 +274,17 @@ func (i Interfaces) genInterface(name string) error {
 	}
 	fmt.Fprintf(out, "\tgomock \"github.com/golang/mock/gomock\"\n")
 	fmt.Fprintf(out, ")\n\n")
-	for tname := range info.types {
+	for tname, t := range info.types {
+		methods, err := i.getMethods(name, tname)
+		if err != nil {
+			// Only aliases of interfaces need to be mocked, so only warn if
+			// this is an actual interface
+			if !t.alias {
+				warnf("%s: not mocking interface %s: %s", t.pos, tname, err)
+			}
+			continue
+		}
+
 		fmt.Fprintf(out, "type Mock%s struct{int}\n", tname)
 		fmt.Fprintf(out, "type _mock_%s_rec struct{\n", tname)
 		fmt.Fprintf(out, "\tmock *Mock%s\n", tname)
@@ -218,11 +301,6 @@ func (i Interfaces) genInterface(name string) error {
 		fmt.Fprintf(out, "\treturn &_mock_%s_rec{_m}\n", tname)
 		fmt.Fprintf(out, "}\n\n")
 
-		methods, err := i.getMethods(name, tname)
-		if err != nil {
-			return Cerr{"getMethods", err}
-		}
-
 		for _, m := range methods {
 			m.recv.expr = "*Mock" + tname
 			m.writeMock(out)
@@ -242,6 +320,10 @@ func (i Interfaces) genExtInterface(name string, extPkg string) error {
 	}
 	defer out.Close()
 
+	for _, msg := range info.skipped {
+		warnf("%s", msg)
+	}
+
 	fmt.Fprintf(out, "package %s\n\n", name)
 	fmt.Fprintf(out, "import (\n")
 	fmt.Fprintf(out, "\t. \"%s\"\n", extPkg)
@@ -257,9 +339,26 @@ func (i Interfaces) genExtInterface(name string, extPkg string) error {
 
 	fmt.Fprintf(out, "func SetController(controller *gomock.Controller) {\n")
 	fmt.Fprintf(out, "\t_ctrl = controller\n")
+	fmt.Fprintf(out, "}\n\n")
+
+	fmt.Fprintf(out, "func _ifaceCtrl(name string) *gomock.Controller {\n")
+	fmt.Fprintf(out, "\tif _ctrl == nil {\n")
+	fmt.Fprintf(out, "\t\tpanic(\"withmock: \" + name + \" used in interface mock package %s before a controller was set (call SetController first)\")\n", name)
+	fmt.Fprintf(out, "\t}\n")
+	fmt.Fprintf(out, "\treturn _ctrl\n")
 	fmt.Fprintf(out, "}\n")
 
-	for tname := range info.types {
+	for tname, t := range info.types {
+		methods, err := i.getMethods(name, tname)
+		if err != nil {
+			// Only aliases of interfaces need to be mocked, so only warn if
+			// this is an actual interface
+			if !t.alias {
+				warnf("%s: not mocking interface %s: %s", t.pos, tname, err)
+			}
+			continue
+		}
+
 		fmt.Fprintf(out, "type Mock%s struct{int}\n", tname)
 		fmt.Fprintf(out, "type _mock_%s_rec struct{\n", tname)
 		fmt.Fprintf(out, "\tmock *Mock%s\n", tname)
@@ -273,11 +372,6 @@ func (i Interfaces) genExtInterface(name string, extPkg string) error {
 		fmt.Fprintf(out, "\treturn &_mock_%s_rec{_m}\n", tname)
 		fmt.Fprintf(out, "}\n\n")
 
-		methods, err := i.getMethods(name, tname)
-		if err != nil {
-			return err
-		}
-
 		for _, m := range methods {
 			m.recv.expr = "*Mock" + tname
 			m.writeMock(out)
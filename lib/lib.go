@@ -7,12 +7,15 @@ package lib
 import (
 	"bytes"
 	"fmt"
+	"go/ast"
 	"go/parser"
 	"go/token"
 	"log"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"sort"
+	"strconv"
 	"strings"
 )
 
@@ -34,6 +37,11 @@ func LookupImportPath(impPath string) (string, error) {
 	return path, nil
 }
 
+// warnf reports a problem that isn't bad enough to stop us from carrying on.
+func warnf(format string, args ...interface{}) {
+	fmt.Fprintf(os.Stderr, "WARNING: "+format+"\n", args...)
+}
+
 func GetOutput(name string, args ...string) (string, error) {
 	return GetCmdOutput(exec.Command(name, args...))
 }
@@ -83,9 +91,14 @@ func hasNonGoCode(impPath string) (bool, error) {
 	return nonGoCode, filepath.Walk(src, fn)
 }
 
-func GetImports(path string, tests bool) (importSet, error) {
+// GetImports returns the imports of the package at path.  The test files are
+// only included if d is not nil, in which case d holds the directives found in
+// them (see readDirectives).
+func GetImports(path string, d *directives) (importSet, error) {
 	imports := make(importSet)
 
+	tests := d != nil
+
 	isGoFile := func(info os.FileInfo) bool {
 		if info.IsDir() {
 			return false
@@ -103,21 +116,31 @@ func GetImports(path string, tests bool) (importSet, error) {
 		return nil, err
 	}
 
+	if d == nil {
+		d = &directives{}
+	}
+
+	testImports := make(map[string]bool)
+
 	for _, pkg := range pkgs {
-		for _, file := range pkg.Files {
+		for name, file := range pkg.Files {
 			for _, i := range file.Imports {
-				path := strings.Trim(i.Path.Value, "\"")
+				path, marked := importPath(i)
+				if strings.HasSuffix(name, "_test.go") {
+					testImports[path] = true
+				}
 				comment := strings.TrimSpace(i.Comment.Text())
+				comment = d.comment(path, comment)
 
-				if strings.HasPrefix(path, "_mock_/") {
-					path = path[7:]
+				if marked {
 					comment = "mock"
 				}
 
 				mode := importNormal
 				path2 := ""
+				_, _, isMock := parseMockComment(comment)
 				switch {
-				case strings.ToLower(comment) == "mock":
+				case isMock:
 					mode = importMock
 				case strings.HasPrefix(comment, "replace("):
 					mode = importReplace
@@ -132,11 +155,206 @@ func GetImports(path string, tests bool) (importSet, error) {
 		}
 	}
 
+	for _, path := range d.unused(testImports) {
+		warnf("%s: withmock directive for %s, but no test file imports it",
+			d.pos[path], path)
+	}
+
 	return imports, nil
 }
 
-func GetMockedPackages(path string) (map[string]string, error) {
-	imports := make(map[string]string)
+// directives holds the withmock directives found in the test files of a
+// package.  As well as "withmock:self", imports can be marked using
+// "withmock:mock <path>" and "withmock:replace <path> => <path>", which have
+// the same effect as a comment on the import, but can't be lost when the
+// imports are reformatted.
+type directives struct {
+	self    bool
+	mock    map[string]string         // import path -> mock mark
+	replace map[string]string         // import path -> replacement package
+	pos     map[string]token.Position // import path -> directive position
+}
+
+// readDirectives returns the directives found in the test files in dir.  Only
+// comments before the end of the imports are seen.  The directives are read
+// once for each package being tested, and then passed to everything that needs
+// them.
+func readDirectives(dir string) (*directives, error) {
+	isTestFile := func(info os.FileInfo) bool {
+		if info.IsDir() {
+			return false
+		}
+		return strings.HasSuffix(info.Name(), "_test.go")
+	}
+
+	fset := token.NewFileSet()
+	pkgs, err := parser.ParseDir(fset, dir, isTestFile,
+		parser.ImportsOnly|parser.ParseComments)
+	if err != nil {
+		return nil, err
+	}
+
+	d := &directives{
+		mock:    make(map[string]string),
+		replace: make(map[string]string),
+		pos:     make(map[string]token.Position),
+	}
+
+	for _, pkg := range pkgs {
+		for _, file := range pkg.Files {
+			for _, group := range file.Comments {
+				for _, c := range group.List {
+					text := strings.TrimSpace(strings.TrimPrefix(c.Text, "//"))
+					pos := fset.Position(c.Pos())
+					if err := d.parse(text, pos); err != nil {
+						return nil, fmt.Errorf("%s: %s", pos, err)
+					}
+				}
+			}
+		}
+	}
+
+	return d, nil
+}
+
+// parse adds the directive in text (found at pos) to d, ignoring text that
+// isn't a withmock directive.
+func (d *directives) parse(text string, pos token.Position) error {
+	switch {
+	case text == "withmock:self":
+		d.self = true
+	case strings.HasPrefix(text, "withmock:mock "):
+		// The path can be followed by a list of names, as with a mock comment
+		path := strings.TrimSpace(text[14:])
+		mark := "mock"
+		if i := strings.Index(path, "("); i >= 0 {
+			path, mark = strings.TrimSpace(path[:i]), mark+path[i:]
+		}
+		if _, _, ok := parseMockComment(mark); !ok || path == "" {
+			return fmt.Errorf("invalid directive: %s", text)
+		}
+		path = strings.Trim(path, "\"")
+		d.mock[path] = mark
+		d.pos[path] = pos
+	case strings.HasPrefix(text, "withmock:replace "):
+		parts := strings.Split(text[17:], "=>")
+		if len(parts) != 2 {
+			return fmt.Errorf("invalid directive: %s (expected "+
+				"withmock:replace <path> => <path>)", text)
+		}
+		path := strings.Trim(strings.TrimSpace(parts[0]), "\"")
+		with := strings.Trim(strings.TrimSpace(parts[1]), "\"")
+		if path == "" || with == "" {
+			return fmt.Errorf("invalid directive: %s (expected "+
+				"withmock:replace <path> => <path>)", text)
+		}
+		d.replace[path] = with
+		d.pos[path] = pos
+	}
+
+	return nil
+}
+
+// unused returns the import paths named by directives that aren't in imported,
+// sorted by path.
+func (d *directives) unused(imported map[string]bool) []string {
+	paths := []string{}
+	for path := range d.pos {
+		if !imported[path] {
+			paths = append(paths, path)
+		}
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+// comment returns the comment to use for an import of path, which is the
+// comment actually on the import if that is a mark, or the equivalent of any
+// directive for path.  Other comments on the import (e.g. explaining why it is
+// needed) don't stop a directive from applying.
+func (d *directives) comment(path, comment string) string {
+	if _, _, ok := parseMockComment(comment); ok {
+		return comment
+	}
+	if strings.HasPrefix(comment, "replace(") {
+		return comment
+	}
+	if mark, found := d.mock[path]; found {
+		return mark
+	}
+	if with, found := d.replace[path]; found {
+		return "replace(" + with + ")"
+	}
+	return comment
+}
+
+// importPath returns the path imported by i.  An import path can be given a
+// "_mock_/" prefix to mark it to be mocked, in which case the prefix is removed
+// and marked is true.
+func importPath(i *ast.ImportSpec) (path string, marked bool) {
+	path, err := strconv.Unquote(i.Path.Value)
+	if err != nil {
+		// The parser has already checked the syntax, so this shouldn't happen
+		path = strings.Trim(i.Path.Value, "\"`")
+	}
+
+	if strings.HasPrefix(path, "_mock_/") {
+		return path[7:], true
+	}
+
+	return path, false
+}
+
+// MockedImport describes an import that has been marked to be mocked.  The
+// whole package is mocked unless Names is set, in which case only the listed
+// functions and methods are mocked - or, if Except is set, everything except
+// the listed functions and methods.  Name is the name that the package is
+// imported as (which may be "." or "_").
+type MockedImport struct {
+	Name   string
+	Path   string
+	Names  []string
+	Except bool
+}
+
+// parseMockComment parses the comment on an import to see if it is a mock
+// mark.  As well as a plain "mock", the functions and methods to be mocked can
+// be listed (e.g. "mock(Open,Stat,*File.Close)"), or the ones that shouldn't
+// be mocked (e.g. "mock(except=Getenv)").
+func parseMockComment(comment string) (names []string, except, ok bool) {
+	lower := strings.ToLower(comment)
+
+	if lower == "mock" {
+		return nil, false, true
+	}
+
+	if !strings.HasPrefix(lower, "mock(") || !strings.HasSuffix(lower, ")") {
+		return nil, false, false
+	}
+
+	list := strings.TrimSpace(comment[5 : len(comment)-1])
+	if strings.HasPrefix(list, "except=") {
+		except = true
+		list = list[7:]
+	}
+
+	for _, name := range strings.Split(list, ",") {
+		// Methods are always named using the base type name, whether or not
+		// they have a pointer receiver.
+		name = strings.TrimPrefix(strings.TrimSpace(name), "*")
+		if name != "" {
+			names = append(names, name)
+		}
+	}
+
+	return names, except, true
+}
+
+// GetMockedPackages returns the mocked imports of the test file at path, in the
+// order that they are imported, using the directives in d.  There can be more
+// than one import with the same name (e.g. two dot imports).
+func GetMockedPackages(path string, d *directives, cfg *Config) ([]*MockedImport, error) {
+	imports := []*MockedImport{}
 
 	fset := token.NewFileSet()
 	file, err := parser.ParseFile(fset, path, nil,
@@ -146,10 +364,10 @@ func GetMockedPackages(path string) (map[string]string, error) {
 	}
 
 	for _, i := range file.Imports {
-		impPath := strings.Trim(i.Path.Value, "\"")
-		comment := strings.TrimSpace(i.Comment.Text())
-		mock := strings.ToLower(comment) == "mock"
-		if strings.HasPrefix(impPath, "_mock_/") {
+		impPath, marked := importPath(i)
+		comment := d.comment(impPath, strings.TrimSpace(i.Comment.Text()))
+		names, except, mock := parseMockComment(comment)
+		if marked || cfg.isMocked(impPath) {
 			mock = true
 		}
 
@@ -157,16 +375,23 @@ func GetMockedPackages(path string) (map[string]string, error) {
 			continue
 		}
 
+		mi := &MockedImport{
+			Path:   impPath,
+			Names:  names,
+			Except: except,
+		}
+
 		if i.Name != nil {
-			imports[i.Name.String()] = impPath
+			mi.Name = i.Name.String()
 		} else {
 			// TODO: pkgName for vendor paths?
-			name, err := getPackageName(impPath, filepath.Dir(path), "")
+			mi.Name, err = getPackageName(impPath, filepath.Dir(path), "")
 			if err != nil {
 				return nil, err
 			}
-			imports[name] = impPath
 		}
+
+		imports = append(imports, mi)
 	}
 
 	return imports, nil
@@ -192,45 +417,96 @@ func getStdlibImports(path string) (map[string]bool, error) {
 
 // Import "marks":
 //
-//  _ : mock
-//  + : normal (no mark actually applied)
-//  @ : test
-//  = : replace
+// When a package needs to be built in more than one way (e.g. mocked and
+// unmocked versions of a standard library package), each version is given a
+// label, which is the import path used for that version.  Labels are kept
+// under labelRoot, which can never clash with a real import path (the .invalid
+// TLD is reserved), e.g. "net/http" mocked is "withmock.invalid/mock/net/http":
+//
+//  mock    : mocked version of the package
+//  normal  : the package itself (no mark actually applied)
+//  test    : the package under test
+//  replace : the package that replaces the original
 type mark string
 
 const (
 	noMark      mark = ""
-	normalMark  mark = "+"
-	mockMark    mark = "_"
-	testMark    mark = "@"
-	replaceMark mark = "="
+	normalMark  mark = "normal"
+	mockMark    mark = "mock"
+	testMark    mark = "test"
+	replaceMark mark = "replace"
 )
 
+const labelRoot = "withmock.invalid"
+
 func markImport(name string, m mark) string {
 	switch m {
 	case noMark, normalMark:
 		return name
 	case mockMark, testMark, replaceMark:
-		if len(name) < 2 {
-			panic(fmt.Sprintf("Unable to mark name (too short): %s", name))
-		}
-		return string(name[0]) + string(m) + name[2:]
+		return labelRoot + "/" + string(m) + "/" + name
 	default:
 		panic(fmt.Sprintf("Unknown import mark: %s", m))
 	}
 }
 
+// splitLabel returns the mark and the original import path of label.
+func splitLabel(label string) (mark, string) {
+	parts := strings.SplitN(label, "/", 3)
+	if len(parts) == 3 && parts[0] == labelRoot {
+		switch m := mark(parts[1]); m {
+		case mockMark, testMark, replaceMark:
+			return m, parts[2]
+		}
+	}
+	return normalMark, label
+}
+
 func getMark(label string) mark {
-	switch label[0] {
-	case mockMark[0]:
-		return mockMark
-	case testMark[0]:
-		return testMark
-	case replaceMark[0]:
-		return replaceMark
-	default:
-		return normalMark
+	m, _ := splitLabel(label)
+	return m
+}
+
+// unlabel replaces every label in s (e.g. in compiler output, or an error
+// message) with the original import path.  Only complete labels are
+// replaced, i.e. labelRoot followed by a known mark, and not part of a longer
+// name.
+func unlabel(s string) string {
+	prefix := labelRoot + "/"
+
+	out := make([]byte, 0, len(s))
+	for {
+		i := strings.Index(s, prefix)
+		if i < 0 {
+			break
+		}
+		out = append(out, s[:i]...)
+		rest := s[i+len(prefix):]
+		s = rest
+		if len(out) > 0 && isPathChar(out[len(out)-1]) {
+			out = append(out, prefix...)
+			continue
+		}
+		found := false
+		for _, m := range []mark{mockMark, testMark, replaceMark} {
+			if strings.HasPrefix(rest, string(m)+"/") {
+				s = rest[len(m)+1:]
+				found = true
+				break
+			}
+		}
+		if !found {
+			out = append(out, prefix...)
+		}
 	}
+	return string(append(out, s...))
+}
+
+// isPathChar returns true if c can appear in a domain name (i.e. the first
+// element of an import path).
+func isPathChar(c byte) bool {
+	return c == '.' || c == '-' || c == '_' || c >= '0' && c <= '9' ||
+		c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
 }
 
 func GenPkg(srcPath, dstRoot, name string, mock bool, cfg *MockConfig) (importSet, error) {
@@ -336,7 +612,7 @@ func ReplacePkg(srcPath, dstRoot, from, as string) (importSet, error) {
 	}
 
 	// Extract the imports from the package source
-	imports, err := GetImports(src, false)
+	imports, err := GetImports(src, nil)
 	if err != nil {
 		return nil, Cerr{"GetImports", err}
 	}
@@ -368,7 +644,7 @@ func LinkPkg(srcPath, dstRoot, name string) (importSet, error) {
 	}
 
 	// Extract the imports from the package source
-	imports, err := GetImports(src, false)
+	imports, err := GetImports(src, nil)
 	if err != nil {
 		return nil, Cerr{"GetImports", err}
 	}
@@ -388,7 +664,10 @@ func exists(path string) bool {
 	panic(err)
 }
 
-func MockImports(src, dst string, names map[string]string, cfg *Config) error {
+// MockImports mirrors the package at src into dst, rewriting the imports of the
+// Go files using names.  The test files are also rewritten using the directives
+// in d.
+func MockImports(src, dst string, names map[string]string, d *directives, cfg *Config) error {
 	fn := func(path string, info os.FileInfo, err error) error {
 		if err != nil {
 			return err
@@ -414,7 +693,7 @@ func MockImports(src, dst string, names map[string]string, cfg *Config) error {
 		if !strings.HasSuffix(path, ".go") {
 			return os.Symlink(path, target)
 		} else {
-			return mockFileImports(path, target, names, cfg)
+			return mockFileImports(path, target, names, d, cfg)
 		}
 	}
 
@@ -422,6 +701,68 @@ func MockImports(src, dst string, names map[string]string, cfg *Config) error {
 	return filepath.Walk(src, fn)
 }
 
+// RewriteImports rewrites the imports of the Go files in dir in place, using
+// names in the same way as MockImports.
+func RewriteImports(dir string, names map[string]string, cfg *Config) error {
+	files, err := filepath.Glob(filepath.Join(dir, "*.go"))
+	if err != nil {
+		return Cerr{"filepath.Glob", err}
+	}
+
+	// Only dependencies are rewritten in place, and their test files are never
+	// built, so there are no directives to apply.
+	d := &directives{}
+
+	for _, path := range files {
+		tmp := path + ".tmp"
+		if err := mockFileImports(path, tmp, names, d, cfg); err != nil {
+			return Cerr{"mockFileImports", err}
+		}
+		if err := os.Rename(tmp, path); err != nil {
+			return Cerr{"os.Rename", err}
+		}
+	}
+
+	return nil
+}
+
+// MockSelf writes a mock version of the package under test found at src into
+// dst, along with the test files.  The mock code is generated into stage first,
+// and then the imports are rewritten on the way to dst in the same way as
+// MockImports.
+func MockSelf(src, dst, stage, name string, names map[string]string, d *directives, cfg *Config) error {
+	err := os.MkdirAll(stage, 0700)
+	if err != nil {
+		return Cerr{"MkdirAll", err}
+	}
+
+	mcfg := cfg.Mock(name)
+	mcfg.MatchOSArch = true
+	_, err = MakePkg(src, stage, name, true, mcfg)
+	if err != nil {
+		return Cerr{"MakePkg", err}
+	}
+
+	// MakePkg ignores the test files, so we need to add them to stage so that
+	// they get copied over as well
+	tests, err := filepath.Glob(filepath.Join(src, "*_test.go"))
+	if err != nil {
+		return Cerr{"filepath.Glob", err}
+	}
+	for _, path := range tests {
+		err := os.Symlink(path, filepath.Join(stage, filepath.Base(path)))
+		if err != nil {
+			return Cerr{"os.Symlink", err}
+		}
+	}
+
+	if err := MockImports(stage, dst, names, d, cfg); err != nil {
+		return Cerr{"MockImports", err}
+	}
+
+	return nil
+}
+
 func symlinkPackage(src, dst string) error {
 	fn := func(path string, info os.FileInfo, err error) error {
 		if err != nil {
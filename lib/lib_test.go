@@ -0,0 +1,109 @@
+// Copyright 2013 Julian Phillips.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package lib
+
+import (
+	"testing"
+)
+
+func TestMarkImportRoundTrip(t *testing.T) {
+	paths := []string{
+		"C",
+		"io",
+		"net/http",
+		"github.com/qur/withmock/lib",
+		"_/home/user/code/pkg",
+		"example.com/mock/test",
+	}
+
+	for _, path := range paths {
+		for _, m := range []mark{mockMark, testMark, replaceMark} {
+			label := markImport(path, m)
+			if label == path {
+				t.Errorf("markImport(%q, %s): label is the same as the path",
+					path, m)
+			}
+
+			gotMark, gotPath := splitLabel(label)
+			if gotMark != m || gotPath != path {
+				t.Errorf("splitLabel(%q): expected (%s, %q), got (%s, %q)",
+					label, m, path, gotMark, gotPath)
+			}
+			if getMark(label) != m {
+				t.Errorf("getMark(%q): expected %s, got %s", label, m,
+					getMark(label))
+			}
+		}
+
+		for _, m := range []mark{noMark, normalMark} {
+			if label := markImport(path, m); label != path {
+				t.Errorf("markImport(%q, %s): expected %q, got %q", path, m,
+					path, label)
+			}
+		}
+
+		if m := getMark(path); m != normalMark {
+			t.Errorf("getMark(%q): expected %s, got %s", path, normalMark, m)
+		}
+	}
+}
+
+func TestSplitLabel(t *testing.T) {
+	tests := []struct {
+		label string
+		mark  mark
+		path  string
+	}{
+		{"withmock.invalid/mock/a", mockMark, "a"},
+		{"withmock.invalid/test/ab", testMark, "ab"},
+		{"withmock.invalid/mock/withmock.invalid/test/x", mockMark,
+			"withmock.invalid/test/x"},
+		// Not labels, so returned unchanged
+		{"withmock.invalid/mock", normalMark, "withmock.invalid/mock"},
+		{"withmock.invalid/normal/x", normalMark, "withmock.invalid/normal/x"},
+		{"withmock.invalid/other/x", normalMark, "withmock.invalid/other/x"},
+		{"example.withmock.invalid/mock/x", normalMark,
+			"example.withmock.invalid/mock/x"},
+		{"m", normalMark, "m"},
+		{"", normalMark, ""},
+	}
+
+	for _, test := range tests {
+		m, path := splitLabel(test.label)
+		if m != test.mark || path != test.path {
+			t.Errorf("splitLabel(%q): expected (%s, %q), got (%s, %q)",
+				test.label, test.mark, test.path, m, path)
+		}
+	}
+}
+
+func TestUnlabel(t *testing.T) {
+	tests := []struct {
+		in, out string
+	}{
+		{"withmock.invalid/mock/net/http", "net/http"},
+		{"# withmock.invalid/test/example.com/app\n" +
+			"withmock.invalid/test/example.com/app/app.go:10: undefined: " +
+			"withmock.invalid/mock/example.com/ext.Missing\n",
+			"# example.com/app\n" +
+				"example.com/app/app.go:10: undefined: example.com/ext.Missing\n"},
+		{`cannot find package "withmock.invalid/replace/a" in any of:`,
+			`cannot find package "a" in any of:`},
+		{"panic: withmock.invalid/mock/io.Reader(nil)",
+			"panic: io.Reader(nil)"},
+		// Only complete labels are replaced
+		{"withmock.invalid/", "withmock.invalid/"},
+		{"withmock.invalid/normal/x", "withmock.invalid/normal/x"},
+		{"withmock.invalid/mockery/x", "withmock.invalid/mockery/x"},
+		{"example.withmock.invalid/mock/x", "example.withmock.invalid/mock/x"},
+		{"no labels here", "no labels here"},
+	}
+
+	for _, test := range tests {
+		if out := unlabel(test.in); out != test.out {
+			t.Errorf("unlabel(%q): expected %q, got %q", test.in, test.out, out)
+		}
+	}
+}
@@ -15,7 +15,10 @@ import (
 	"os/exec"
 	"path"
 	"path/filepath"
+	"sort"
 	"strings"
+	"unicode"
+	"unicode/utf8"
 )
 
 func isLocalExpr(expr string) (ret bool) {
@@ -87,6 +90,9 @@ type funcInfo struct {
 	export       string
 	varidic      bool
 	realDisabled bool
+	funcVar      bool
+	untyped      bool
+	unexported   bool
 	recv         struct {
 		name, expr string
 	}
@@ -121,7 +127,7 @@ func (fi *funcInfo) writeReal(out io.Writer) {
 	if fi.IsMethod() {
 		fmt.Fprintf(out, "(%s %s) ", fi.recv.name, fi.recv.expr)
 	}
-	if ast.IsExported(fi.name) {
+	if ast.IsExported(fi.name) || fi.unexported {
 		fmt.Fprintf(out, "_real_")
 	}
 	fmt.Fprintf(out, "%s(", fi.name)
@@ -153,7 +159,7 @@ func (fi *funcInfo) writeStub(out io.Writer) {
 	if fi.IsMethod() {
 		fmt.Fprintf(out, "(%s %s) ", fi.recv.name, fi.recv.expr)
 	}
-	if ast.IsExported(fi.name) {
+	if ast.IsExported(fi.name) || fi.unexported {
 		fmt.Fprintf(out, "_real_")
 	}
 	fmt.Fprintf(out, "%s(", fi.name)
@@ -231,16 +237,60 @@ func (fi *funcInfo) retTypes() []string {
 	return results
 }
 
+func (fi *funcInfo) scopedName() string {
+	if !fi.IsMethod() {
+		return fi.name
+	}
+	if fi.recv.expr[0] == '*' {
+		return fi.recv.expr[1:] + "." + fi.name
+	}
+	return fi.recv.expr + "." + fi.name
+}
+
+func (fi *funcInfo) callArgs(args int) string {
+	names := make([]string, args)
+	for i := range names {
+		names[i] = fmt.Sprintf("p%d", i)
+	}
+	s := strings.Join(names, ", ")
+	if fi.varidic {
+		s += "..."
+	}
+	return s
+}
+
+func (fi *funcInfo) writeArgs(out io.Writer, args int) {
+	fixed := args
+	if fi.varidic {
+		fixed--
+	}
+	fmt.Fprintf(out, "\targs := []interface{}{")
+	for i := 0; i < fixed; i++ {
+		if i > 0 {
+			fmt.Fprintf(out, ", ")
+		}
+		fmt.Fprintf(out, "p%d", i)
+	}
+	fmt.Fprintf(out, "}\n")
+	if fi.varidic {
+		fmt.Fprintf(out, "\tfor _, v := range p%d {\n", args-1)
+		fmt.Fprintf(out, "\t\targs = append(args, v)\n")
+		fmt.Fprintf(out, "\t}\n")
+	}
+}
+
+func (fi *funcInfo) writeRealCall(out io.Writer, args int) {
+	if fi.IsMethod() {
+		fmt.Fprintf(out, "_m.")
+	}
+	fmt.Fprintf(out, "_real_%s(%s)\n", fi.name, fi.callArgs(args))
+}
+
 func (fi *funcInfo) writeMock(out io.Writer) {
-	scopedName := fi.name
+	scopedName := fi.scopedName()
 	fmt.Fprintf(out, "func ")
 	if fi.IsMethod() {
 		fmt.Fprintf(out, "(_m %s) ", fi.recv.expr)
-		if fi.recv.expr[0] == '*' {
-			scopedName = fi.recv.expr[1:] + "." + scopedName
-		} else {
-			scopedName = fi.recv.expr + "." + scopedName
-		}
 	}
 	fmt.Fprintf(out, "%s(", fi.name)
 	args := fi.writeParams(out)
@@ -255,17 +305,7 @@ func (fi *funcInfo) writeMock(out io.Writer) {
 		if len(fi.results) > 0 {
 			fmt.Fprintf(out, "return ")
 		}
-		fmt.Fprintf(out, "_pkgMock.%s(", fi.name)
-		for i := 0; i < args; i++ {
-			if i > 0 {
-				fmt.Fprintf(out, ", ")
-			}
-			fmt.Fprintf(out, "p%d", i)
-		}
-		if fi.varidic {
-			fmt.Fprintf(out, "...")
-		}
-		fmt.Fprintf(out, ")\n")
+		fmt.Fprintf(out, "_pkgMock.%s(%s)\n", fi.name, fi.callArgs(args))
 		fmt.Fprintf(out, "}\n")
 		fmt.Fprintf(out, "func (_m *_packageMock) %s(", fi.name)
 		fi.writeParams(out)
@@ -275,80 +315,100 @@ func (fi *funcInfo) writeMock(out io.Writer) {
 		}
 		fmt.Fprintf(out, "{\n")
 	}
-	if fi.varidic {
-		if !fi.realDisabled {
-			fmt.Fprintf(out, "\tif (!_allMocked && !_enabledMocks[\"%s\"]) "+
-				"|| _disabledMocks[\"%s\"] {\n", scopedName, scopedName)
-			fmt.Fprintf(out, "\t\t")
-			if len(fi.results) > 0 {
-				fmt.Fprintf(out, "return ")
-			}
-			if fi.IsMethod() {
-				fmt.Fprintf(out, "_m.")
-			}
-			fmt.Fprintf(out, "_real_%s(", fi.name)
-			for i := 0; i < args-1; i++ {
-				fmt.Fprintf(out, "p%d, ", i)
-			}
-			fmt.Fprintf(out, "p%d...", args-1)
-			fmt.Fprintf(out, ")\n")
-			if len(fi.results) == 0 {
-				fmt.Fprintf(out, "\treturn")
-			}
-			fmt.Fprintf(out, "\t}\n")
-		}
-		fmt.Fprintf(out, "\targs := []interface{}{")
-		for i := 0; i < args-1; i++ {
-			if i > 0 {
-				fmt.Fprintf(out, ", ")
-			}
-			fmt.Fprintf(out, "p%d", i)
-		}
-		fmt.Fprintf(out, "}\n")
-		fmt.Fprintf(out, "\tfor _, v := range p%d {\n", args-1)
-		fmt.Fprintf(out, "\t\targs = append(args, v)\n")
-		fmt.Fprintf(out, "\t}\n")
+	if fi.realDisabled {
+		fi.writeArgs(out, args)
 		fmt.Fprintf(out, "\t")
 		if len(fi.results) > 0 {
 			fmt.Fprintf(out, "ret := ")
 		}
-		fmt.Fprintf(out, "_ctrl.Call(_m, \"%s\", args...)\n", fi.name)
+		fmt.Fprintf(out, "_ifaceCtrl(\"%s\").Call(_m, \"%s\", args...)\n", scopedName, fi.name)
 	} else {
-		if !fi.realDisabled {
-			fmt.Fprintf(out, "\tif (!_allMocked && !_enabledMocks[\"%s\"]) "+
-				"||  _disabledMocks[\"%s\"] {\n", scopedName, scopedName)
-			fmt.Fprintf(out, "\t\t")
-			if len(fi.results) > 0 {
-				fmt.Fprintf(out, "return ")
-			}
-			if fi.IsMethod() {
-				fmt.Fprintf(out, "_m.")
-			}
-			fmt.Fprintf(out, "_real_%s(", fi.name)
-			for i := 0; i < args; i++ {
+		// Work out where the call should go - the real code, the controller
+		// or (for a loose mock without expectations) nowhere at all.
+		if fi.IsMethod() {
+			fmt.Fprintf(out, "\t_r, _rec, _tr := _routeCall(_m, \"%s\")\n", scopedName)
+		} else {
+			fmt.Fprintf(out, "\t_r, _rec, _tr := _routeCall(nil, \"%s\")\n", scopedName)
+		}
+		fmt.Fprintf(out, "\tif _r == _callReal && !_rec && !_tr {\n")
+		fmt.Fprintf(out, "\t\t")
+		if len(fi.results) > 0 {
+			fmt.Fprintf(out, "return ")
+		}
+		fi.writeRealCall(out, args)
+		if len(fi.results) == 0 {
+			fmt.Fprintf(out, "\t\treturn\n")
+		}
+		fmt.Fprintf(out, "\t}\n")
+		fi.writeArgs(out, args)
+		fmt.Fprintf(out, "\tvar ret []interface{}\n")
+		fmt.Fprintf(out, "\tswitch _r {\n")
+		fmt.Fprintf(out, "\tcase _callReal:\n")
+		fmt.Fprintf(out, "\t\t")
+		if len(returns) > 0 {
+			for i := range returns {
 				if i > 0 {
 					fmt.Fprintf(out, ", ")
 				}
-				fmt.Fprintf(out, "p%d", i)
+				fmt.Fprintf(out, "r%d", i)
 			}
-			fmt.Fprintf(out, ")\n")
-			if len(fi.results) == 0 {
-				fmt.Fprintf(out, "\treturn")
+			fmt.Fprintf(out, " := ")
+		}
+		fi.writeRealCall(out, args)
+		if len(returns) > 0 {
+			fmt.Fprintf(out, "\t\tret = []interface{}{")
+			for i := range returns {
+				if i > 0 {
+					fmt.Fprintf(out, ", ")
+				}
+				fmt.Fprintf(out, "r%d", i)
 			}
-			fmt.Fprintf(out, "\t}\n")
+			fmt.Fprintf(out, "}\n")
 		}
-		fmt.Fprintf(out, "\t")
-		if len(fi.results) > 0 {
-			fmt.Fprintf(out, "ret := ")
+		fmt.Fprintf(out, "\tcase _callCtrl:\n")
+		fmt.Fprintf(out, "\t\tret = _ctrl.Call(_m, \"%s\", args...)\n", fi.name)
+		fmt.Fprintf(out, "\tcase _callReplay:\n")
+		for i, ret := range returns {
+			fmt.Fprintf(out, "\t\tvar r%d %s\n", i, ret)
 		}
-		fmt.Fprintf(out, "_ctrl.Call(_m, \"%s\"", fi.name)
-		for i := 0; i < args; i++ {
-			fmt.Fprintf(out, ", p%d", i)
+		fmt.Fprintf(out, "\t\t_replayCall(\"%s\"", scopedName)
+		for i := range returns {
+			fmt.Fprintf(out, ", &r%d", i)
 		}
 		fmt.Fprintf(out, ")\n")
+		fmt.Fprintf(out, "\t\tret = []interface{}{")
+		for i := range returns {
+			if i > 0 {
+				fmt.Fprintf(out, ", ")
+			}
+			fmt.Fprintf(out, "r%d", i)
+		}
+		fmt.Fprintf(out, "}\n")
+		fmt.Fprintf(out, "\tdefault:\n")
+		fmt.Fprintf(out, "\t\tret = make([]interface{}, %d)\n", len(returns))
+		fmt.Fprintf(out, "\t}\n")
+		fmt.Fprintf(out, "\tif _rec {\n")
+		if fi.IsMethod() {
+			fmt.Fprintf(out, "\t\t_recordCall(_m, \"%s\", args, ret)\n", scopedName)
+		} else {
+			fmt.Fprintf(out, "\t\t_recordCall(nil, \"%s\", args, ret)\n", scopedName)
+		}
+		fmt.Fprintf(out, "\t}\n")
+		fmt.Fprintf(out, "\tif _tr {\n")
+		if fi.IsMethod() {
+			fmt.Fprintf(out, "\t\t_traceCall(_m, \"%s\", _r, args, ret)\n", scopedName)
+		} else {
+			fmt.Fprintf(out, "\t\t_traceCall(nil, \"%s\", _r, args, ret)\n", scopedName)
+		}
+		fmt.Fprintf(out, "\t}\n")
 	}
+	// Check the types of the values, so that a Return with the wrong types is
+	// reported rather than silently becoming a zero value.
 	for i, ret := range returns {
-		fmt.Fprintf(out, "\tret%d, _ := ret[%d].(%s)\n", i, i, ret)
+		fmt.Fprintf(out, "\tret%d, _ok := ret[%d].(%s)\n", i, i, ret)
+		fmt.Fprintf(out, "\tif !_ok && ret[%d] != nil {\n", i)
+		fmt.Fprintf(out, "\t\t_ctrl.T.Fatalf(\"%%s: return value %%d has type %%T, expected %%s\", %q, %d, ret[%d], %q)\n", scopedName, i, i, ret)
+		fmt.Fprintf(out, "\t}\n")
 	}
 	if len(returns) > 0 {
 		fmt.Fprintf(out, "\treturn ")
@@ -364,8 +424,80 @@ func (fi *funcInfo) writeMock(out io.Writer) {
 }
 
 func (fi *funcInfo) writeRecorder(out io.Writer, recorder string) {
+	fi.writeRecorderMethod(out, recorder, "")
+}
+
+// signature returns the type of the function, e.g. "func(int, ...string) error"
+func (fi *funcInfo) signature() string {
+	params := []string{}
+	for _, param := range fi.params {
+		x := len(param.names)
+		if x == 0 {
+			x = 1
+		}
+		for i := 0; i < x; i++ {
+			params = append(params, param.expr)
+		}
+	}
+	sig := "func(" + strings.Join(params, ", ") + ")"
+	switch returns := fi.retTypes(); len(returns) {
+	case 0:
+	case 1:
+		sig += " " + returns[0]
+	default:
+		sig += " (" + strings.Join(returns, ", ") + ")"
+	}
+	return sig
+}
+
+// writeTypedRecorder writes a recorder method that returns a wrapper around
+// the gomock.Call, where Return, Do and DoAndReturn take the real types so
+// that mistakes are caught by the compiler.
+func (fi *funcInfo) writeTypedRecorder(out io.Writer, recorder string) {
+	call := strings.TrimSuffix(recorder, "_Rec") + "_" + fi.name + "_Call"
+	returns := fi.retTypes()
+	sig := fi.signature()
+
+	fmt.Fprintf(out, "type %s struct {\n", call)
+	fmt.Fprintf(out, "\t*gomock.Call\n")
+	fmt.Fprintf(out, "}\n")
+
+	fmt.Fprintf(out, "func (_c *%s) Return(", call)
+	for i, ret := range returns {
+		if i > 0 {
+			fmt.Fprintf(out, ", ")
+		}
+		fmt.Fprintf(out, "r%d %s", i, ret)
+	}
+	fmt.Fprintf(out, ") *%s {\n", call)
+	fmt.Fprintf(out, "\t_c.Call = _c.Call.Return(")
+	for i := range returns {
+		if i > 0 {
+			fmt.Fprintf(out, ", ")
+		}
+		fmt.Fprintf(out, "r%d", i)
+	}
+	fmt.Fprintf(out, ")\n")
+	fmt.Fprintf(out, "\treturn _c\n")
+	fmt.Fprintf(out, "}\n")
+
+	for _, method := range []string{"Do", "DoAndReturn"} {
+		fmt.Fprintf(out, "func (_c *%s) %s(f %s) *%s {\n", call, method, sig, call)
+		fmt.Fprintf(out, "\t_c.Call = _c.Call.%s(f)\n", method)
+		fmt.Fprintf(out, "\treturn _c\n")
+		fmt.Fprintf(out, "}\n")
+	}
+
+	fi.writeRecorderMethod(out, recorder, call)
+}
+
+func (fi *funcInfo) writeRecorderMethod(out io.Writer, recorder, call string) {
 	args := fi.countParams()
-	fmt.Fprintf(out, "func (_mr *%s) %s(", recorder, fi.name)
+	name := fi.name
+	if fi.unexported {
+		name = exportedName(fi.name)
+	}
+	fmt.Fprintf(out, "func (_mr *%s) %s(", recorder, name)
 	if args > 0 {
 		if fi.varidic {
 			if args > 1 {
@@ -388,7 +520,11 @@ func (fi *funcInfo) writeRecorder(out io.Writer, recorder string) {
 			fmt.Fprintf(out, " interface{}")
 		}
 	}
-	fmt.Fprintf(out, ") *gomock.Call {\n")
+	if call == "" {
+		fmt.Fprintf(out, ") *gomock.Call {\n")
+	} else {
+		fmt.Fprintf(out, ") *%s {\n", call)
+	}
 	if fi.varidic {
 		fmt.Fprintf(out, "\targs := append([]interface{}{")
 		for i := 0; i < args-1; i++ {
@@ -399,7 +535,31 @@ func (fi *funcInfo) writeRecorder(out io.Writer, recorder string) {
 		}
 		fmt.Fprintf(out, "}, p%d...)\n", args-1)
 	}
-	fmt.Fprintf(out, "\treturn _ctrl.RecordCall(_mr.mock, \"%s\"", fi.name)
+	if !fi.realDisabled {
+		fmt.Fprintf(out, "\t_expect(\"%s\")\n", fi.scopedName())
+	}
+	ctrl := "_ctrl"
+	if fi.realDisabled {
+		// Interface mocks have no real code to fall back to, so the
+		// controller must have been set.
+		ctrl = fmt.Sprintf("_ifaceCtrl(\"%s\")", fi.scopedName())
+	}
+	record := fmt.Sprintf("%s.RecordCall(_mr.mock, \"%s\"", ctrl, fi.name)
+	if fi.funcVar {
+		// Function variables aren't methods of the mock, so we need to tell
+		// gomock what the type is.
+		record = fmt.Sprintf("%s.RecordCallWithMethodType(_mr.mock, \"%s\", _funcVarType(\"%s\")", ctrl, fi.name, fi.name)
+	}
+	if fi.unexported {
+		// gomock can't find unexported methods using reflect, so again we
+		// need to provide the type.
+		record = fmt.Sprintf("%s.RecordCallWithMethodType(_mr.mock, \"%s\", _methodType(_mr.mock.%s)", ctrl, fi.name, fi.name)
+	}
+	if call == "" {
+		fmt.Fprintf(out, "\treturn %s", record)
+	} else {
+		fmt.Fprintf(out, "\treturn &%s{%s", call, record)
+	}
 	if fi.varidic {
 		fmt.Fprintf(out, ", args...")
 	} else {
@@ -407,52 +567,314 @@ func (fi *funcInfo) writeRecorder(out io.Writer, recorder string) {
 			fmt.Fprintf(out, ", p%d", i)
 		}
 	}
-	fmt.Fprintf(out, ")\n")
+	fmt.Fprintf(out, ")")
+	if call != "" {
+		fmt.Fprintf(out, "}")
+	}
+	fmt.Fprintf(out, "\n")
+	fmt.Fprintf(out, "}\n")
+}
+
+func (fi *funcInfo) writeRealAccessor(out io.Writer, accessor string) {
+	fmt.Fprintf(out, "func (_r *%s) %s(", accessor, fi.name)
+	args := fi.writeParams(out)
+	fmt.Fprintf(out, ") ")
+	if returns := fi.retTypes(); len(returns) > 0 {
+		fmt.Fprintf(out, "(%s) ", strings.Join(returns, ", "))
+	}
+	fmt.Fprintf(out, "{\n")
+	fmt.Fprintf(out, "\t")
+	if len(fi.results) > 0 {
+		fmt.Fprintf(out, "return ")
+	}
+	if fi.IsMethod() {
+		fmt.Fprintf(out, "_r.mock.")
+	}
+	fmt.Fprintf(out, "_real_%s(%s)\n", fi.name, fi.callArgs(args))
 	fmt.Fprintf(out, "}\n")
 }
 
+// unexportedAccessors returns the names of the methods of _package_URec that
+// give the recorders for unexported methods, keyed by recorder.  The name is
+// normally the type name with the first letter in upper case, but "Type" is
+// added if that would clash with the recorder for an unexported function (or
+// another type).
+func (m *mockGen) unexportedAccessors() map[string]string {
+	bases := make(map[string]string)
+	add := func(recs map[string]string) {
+		for base, urec := range recs {
+			if _, found := bases[urec]; !found || base[0] != '*' {
+				bases[urec] = base
+			}
+		}
+	}
+	add(m.unexportedRecs)
+	for _, recs := range m.taggedRec.u {
+		add(recs)
+	}
+
+	urecs := make([]string, 0, len(bases))
+	for urec := range bases {
+		urecs = append(urecs, urec)
+	}
+	sort.Strings(urecs)
+
+	used := make(map[string]bool)
+	for name := range m.unexportedNames {
+		used[name] = true
+	}
+
+	accessors := make(map[string]string)
+	for _, urec := range urecs {
+		base := strings.TrimPrefix(bases[urec], "*")
+		name := exportedName(base)
+		for used[name] {
+			name += "Type"
+		}
+		used[name] = true
+		accessors[urec] = name
+	}
+
+	return accessors
+}
+
+// writeUnexportedRecorder writes the recorder type for the unexported methods
+// of base, and the method of _package_URec used to get it.
+func writeUnexportedRecorder(out io.Writer, base, urec, accessor string) {
+	fmt.Fprintf(out, "type %s struct {\n", urec)
+	fmt.Fprintf(out, "\tmock %s\n", base)
+	fmt.Fprintf(out, "}\n\n")
+	fmt.Fprintf(out, "func (_ *_package_URec) %s(recv %s) *%s {\n", accessor, base, urec)
+	fmt.Fprintf(out, "\treturn &%s{recv}\n", urec)
+	fmt.Fprintf(out, "}\n\n")
+}
+
+// realAccessor returns the name of the type used to give access to the real
+// code, given the name of the recorder type.
+func realAccessor(recorder string) string {
+	return strings.TrimSuffix(recorder, "_Rec") + "_Real"
+}
+
+// unexportedRecorder returns the name of the recorder type used for the
+// unexported methods of the type with the given recorder.
+func unexportedRecorder(recorder string) string {
+	return strings.TrimSuffix(recorder, "_Rec") + "_URec"
+}
+
+// exportedName returns name with the first letter in upper case, so that it
+// can be used for recorder methods called from other packages.
+func exportedName(name string) string {
+	r, size := utf8.DecodeRuneInString(name)
+	return string(unicode.ToUpper(r)) + name[size:]
+}
+
 type taggedRecorders struct {
 	r map[string]map[string]string
+	u map[string]map[string]string
 }
 
-func (t *taggedRecorders) Add(tags []string, recv, name string) {
-	if t.r == nil {
-		t.r = make(map[string]map[string]string)
-	}
+func tagsKey(tags []string) string {
 	key := "|"
 	for _, line := range tags {
 		key += line + "|"
 	}
+	return key
+}
+
+func (t *taggedRecorders) Add(tags []string, recv, name string) {
+	if t.r == nil {
+		t.r = make(map[string]map[string]string)
+	}
+	key := tagsKey(tags)
 	if _, found := t.r[key]; !found {
 		t.r[key] = make(map[string]string)
 	}
 	t.r[key][recv] = name
 }
 
+// AddUnexported records the recorder for the unexported methods of recv, which
+// are in a file with the given build tags.
+func (t *taggedRecorders) AddUnexported(tags []string, recv, name string) {
+	if t.u == nil {
+		t.u = make(map[string]map[string]string)
+	}
+	key := tagsKey(tags)
+	if _, found := t.u[key]; !found {
+		t.u[key] = make(map[string]string)
+	}
+	t.u[key][recv] = name
+}
+
 type mockGen struct {
-	pkgName        string
-	fset           *token.FileSet
-	srcPath        string
-	mockByDefault  bool
-	mockPrototypes bool
-	extFunctions   []string
-	callInits      bool
-	matchOS        bool
-	types          map[string]ast.Expr
-	recorders      map[string]string
-	taggedRec      taggedRecorders
-	data           io.ReaderAt
-	ifInfo         *ifInfo
-	scopes         map[string]bool
-	initCount      int
-	MOCK           string
-	EXPECT         string
-	ObjEXPECT      string
+	pkgName         string
+	fset            *token.FileSet
+	srcPath         string
+	mockByDefault   bool
+	mockPrototypes  bool
+	extFunctions    []string
+	callInits       bool
+	matchOS         bool
+	types           map[string]ast.Expr
+	aliases         map[string]string
+	funcs           map[string]bool
+	recorders       map[string]string
+	taggedRec       taggedRecorders
+	data            io.ReaderAt
+	ifInfo          *ifInfo
+	scopes          map[string]bool
+	initCount       int
+	loose           string
+	looseFunctions  []string
+	typedRecorders  bool
+	noController    string
+	unexported      bool
+	unexportedFns   map[string]bool
+	unexportedRecs  map[string]string
+	unexportedNames map[string]bool
+	MOCK            string
+	EXPECT          string
+	ObjEXPECT       string
+	ObjREAL         string
+}
+
+// collectFuncs records the names of the package level functions declared in
+// files.
+func (m *mockGen) collectFuncs(files map[string]*ast.File) {
+	for _, file := range files {
+		addFuncs(m.funcs, file)
+	}
+}
+
+// addFuncs adds the names of the package level functions declared in file to
+// funcs.
+func addFuncs(funcs map[string]bool, file *ast.File) {
+	for _, decl := range file.Decls {
+		if d, ok := decl.(*ast.FuncDecl); ok && d.Recv == nil {
+			funcs[d.Name.Name] = true
+		}
+	}
+}
+
+// collectAliases records the local types that any aliases in files refer to.
+func (m *mockGen) collectAliases(files map[string]*ast.File) {
+	for _, file := range files {
+		for _, decl := range file.Decls {
+			d, ok := decl.(*ast.GenDecl)
+			if !ok || d.Tok != token.TYPE {
+				continue
+			}
+			for _, spec := range d.Specs {
+				t := spec.(*ast.TypeSpec)
+				if id, ok := t.Type.(*ast.Ident); ok && t.Assign.IsValid() {
+					m.aliases[t.Name.Name] = id.Name
+				}
+			}
+		}
+	}
+}
+
+// checkNames makes sure that the names used for the generated MOCK, EXPECT,
+// obj.EXPECT and obj.REAL functions won't clash with each other, or with names
+// declared by the package being mocked.
+func (m *mockGen) checkNames(files map[string]*ast.File) error {
+	if m.MOCK == m.EXPECT {
+		return fmt.Errorf("%s: MOCK and EXPECT can't both be called %s",
+			m.pkgName, m.MOCK)
+	}
+	if m.ObjEXPECT == m.ObjREAL {
+		return fmt.Errorf("%s: obj.EXPECT and obj.REAL can't both be called "+
+			"%s", m.pkgName, m.ObjEXPECT)
+	}
+
+	for _, file := range files {
+		for _, decl := range file.Decls {
+			names := []*ast.Ident{}
+			method := false
+
+			switch d := decl.(type) {
+			case *ast.FuncDecl:
+				names = append(names, d.Name)
+				method = d.Recv != nil
+			case *ast.GenDecl:
+				for _, spec := range d.Specs {
+					switch s := spec.(type) {
+					case *ast.TypeSpec:
+						names = append(names, s.Name)
+					case *ast.ValueSpec:
+						names = append(names, s.Names...)
+					}
+				}
+			}
+
+			for _, name := range names {
+				key := ""
+				switch {
+				case method && name.Name == m.ObjEXPECT:
+					key = "obj.EXPECT"
+				case method && name.Name == m.ObjREAL:
+					key = "obj.REAL"
+				case !method && name.Name == m.MOCK:
+					key = "MOCK"
+				case !method && name.Name == m.EXPECT:
+					key = "EXPECT"
+				}
+				if key != "" {
+					return fmt.Errorf("%s: %s clashes with the generated %s "+
+						"(a different name can be set in the config file)",
+						m.fset.Position(name.Pos()), name.Name, key)
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+// resolveAlias returns the type that a method receiver of type expr actually
+// belongs to.  A method declared using an alias is a method of the aliased type,
+// so it needs to share a recorder with the other methods of that type.
+func (m *mockGen) resolveAlias(expr ast.Expr) ast.Expr {
+	switch v := expr.(type) {
+	case *ast.StarExpr:
+		return &ast.StarExpr{X: m.resolveAlias(v.X)}
+	case *ast.Ident:
+		name := v.Name
+		seen := make(map[string]bool)
+		for m.aliases[name] != "" && !seen[name] {
+			seen[name] = true
+			name = m.aliases[name]
+		}
+		return ast.NewIdent(name)
+	}
+	return expr
+}
+
+// typeSpecString returns the source for t, without the leading type keyword.
+func (m *mockGen) typeSpecString(t *ast.TypeSpec) string {
+	s := t.Name.String()
+	if t.TypeParams != nil {
+		params := []string{}
+		for _, field := range t.TypeParams.List {
+			names := make([]string, 0, len(field.Names))
+			for _, ident := range field.Names {
+				names = append(names, ident.Name)
+			}
+			params = append(params, strings.Join(names, ", ")+" "+
+				m.exprString(field.Type))
+		}
+		s += "[" + strings.Join(params, ", ") + "]"
+	}
+	if t.Assign.IsValid() {
+		s += " ="
+	}
+	return s + " " + m.exprString(t.Type)
 }
 
 // MakePkg writes a mock version of the package found at srcPath into dstPath.
 // If dstPath already exists, bad things will probably happen.
 func MakePkg(srcPath, dstPath, pkgName string, mock bool, cfg *MockConfig) (importSet, error) {
+	cfg.applyOverrides()
+
 	isGoFile := func(info os.FileInfo) bool {
 		if info.IsDir() {
 			return false
@@ -513,23 +935,49 @@ func MakePkg(srcPath, dstPath, pkgName string, mock bool, cfg *MockConfig) (impo
 
 	for name, pkg := range pkgs {
 		m := &mockGen{
-			pkgName:        pkgName,
-			fset:           fset,
-			srcPath:        srcPath,
-			mockByDefault:  mock,
-			mockPrototypes: cfg.MockPrototypes,
-			callInits:      !cfg.IgnoreInits,
-			matchOS:        cfg.MatchOSArch,
-			types:          make(map[string]ast.Expr),
-			recorders:      make(map[string]string),
-			ifInfo:         newIfInfo(filepath.Join(dstPath, name+"_ifmocks.go")),
-			MOCK:           cfg.MOCK,
-			EXPECT:         cfg.EXPECT,
-			ObjEXPECT:      cfg.ObjEXPECT,
+			pkgName:         pkgName,
+			fset:            fset,
+			srcPath:         srcPath,
+			mockByDefault:   mock,
+			mockPrototypes:  cfg.MockPrototypes,
+			callInits:       !cfg.IgnoreInits,
+			matchOS:         cfg.MatchOSArch,
+			loose:           cfg.Loose,
+			looseFunctions:  cfg.LooseFunctions,
+			typedRecorders:  isSet(cfg.TypedRecorders),
+			noController:    cfg.NoController,
+			unexported:      isSet(cfg.Unexported),
+			unexportedFns:   make(map[string]bool),
+			unexportedRecs:  make(map[string]string),
+			unexportedNames: make(map[string]bool),
+			types:           make(map[string]ast.Expr),
+			aliases:         make(map[string]string),
+			funcs:           make(map[string]bool),
+			recorders:       make(map[string]string),
+			ifInfo:          newIfInfo(filepath.Join(dstPath, name+"_ifmocks.go")),
+			MOCK:            cfg.MOCK,
+			EXPECT:          cfg.EXPECT,
+			ObjEXPECT:       cfg.ObjEXPECT,
+			ObjREAL:         cfg.ObjREAL,
 		}
 
 		m.ifInfo.EXPECT = m.EXPECT
 
+		for _, name := range cfg.UnexportedFunctions {
+			m.unexportedFns[name] = true
+		}
+
+		// Aliases can be declared in any file, so we need to find them all
+		// before we look at any methods.
+		m.collectAliases(pkg.Files)
+
+		// Function variables can be initialised from functions in any file.
+		m.collectFuncs(pkg.Files)
+
+		if err := m.checkNames(pkg.Files); err != nil {
+			return nil, err
+		}
+
 		processed := 0
 
 		for path, file := range pkg.Files {
@@ -772,6 +1220,12 @@ func (m *mockGen) exprString(exp ast.Expr) string {
 		return s
 	case *ast.IndexExpr:
 		return m.exprString(v.X) + "[" + m.exprString(v.Index) + "]"
+	case *ast.IndexListExpr:
+		indices := make([]string, 0, len(v.Indices))
+		for _, index := range v.Indices {
+			indices = append(indices, m.exprString(index))
+		}
+		return m.exprString(v.X) + "[" + strings.Join(indices, ", ") + "]"
 	case *ast.InterfaceType:
 		if len(v.Methods.List) == 0 {
 			return "interface{}"
@@ -781,6 +1235,11 @@ func (m *mockGen) exprString(exp ast.Expr) string {
 				s += "\t"
 				switch v := field.Type.(type) {
 				case *ast.FuncType:
+					if len(field.Names) == 0 {
+						// func type as a type set element
+						s += m.exprString(v) + "\n"
+						continue
+					}
 					s += field.Names[0].Name + "("
 					if v.Params != nil {
 						for i, param := range v.Params.List {
@@ -831,7 +1290,8 @@ func (m *mockGen) exprString(exp ast.Expr) string {
 				case *ast.Ident:
 					s += m.exprString(v)
 				default:
-					panic(fmt.Sprintf("Don't expect %T in interface", field.Type))
+					// embedded generic interface, or type set element
+					s += m.exprString(v)
 				}
 				s += "\n"
 			}
@@ -930,7 +1390,21 @@ func fixup(filename string) error {
 func (m *mockGen) pkg(out io.Writer, name string) error {
 	fmt.Fprintf(out, "package %s\n\n", name)
 
-	fmt.Fprintf(out, "import \"github.com/golang/mock/gomock\"\n\n")
+	fmt.Fprintf(out, "import (\n")
+	fmt.Fprintf(out, "\t\"github.com/golang/mock/gomock\"\n")
+	fmt.Fprintf(out, "\t_json \"encoding/json\"\n")
+	fmt.Fprintf(out, "\t_fmt \"fmt\"\n")
+	fmt.Fprintf(out, "\t_errors \"errors\"\n")
+	fmt.Fprintf(out, "\t_io \"io\"\n")
+	fmt.Fprintf(out, "\t_ioutil \"io/ioutil\"\n")
+	fmt.Fprintf(out, "\t_os \"os\"\n")
+	fmt.Fprintf(out, "\t_filepath \"path/filepath\"\n")
+	fmt.Fprintf(out, "\t_reflect \"reflect\"\n")
+	fmt.Fprintf(out, "\t_runtime \"runtime\"\n")
+	fmt.Fprintf(out, "\t_strconv \"strconv\"\n")
+	fmt.Fprintf(out, "\t_strings \"strings\"\n")
+	fmt.Fprintf(out, "\t_sync \"sync\"\n")
+	fmt.Fprintf(out, ")\n\n")
 
 	fmt.Fprintf(out, "type _meta struct{}\n")
 	fmt.Fprintf(out, "type _packageMock struct{int}\n")
@@ -942,20 +1416,481 @@ func (m *mockGen) pkg(out io.Writer, name string) error {
 	fmt.Fprintf(out, "\t_allMocked = false\n")
 	fmt.Fprintf(out, "\t_enabledMocks = make(map[string]bool)\n")
 	fmt.Fprintf(out, "\t_disabledMocks = make(map[string]bool)\n")
+	fmt.Fprintf(out, "\t_allLoose = %v\n", m.loose != "" && len(m.looseFunctions) == 0)
+	fmt.Fprintf(out, "\t_looseReal = %v\n", m.loose == "real")
+	fmt.Fprintf(out, "\t_noCtrlReal = %v\n", m.noController == "real")
+	fmt.Fprintf(out, "\t_enabledLoose = map[string]bool{")
+	for _, name := range m.looseFunctions {
+		fmt.Fprintf(out, "%q: true, ", name)
+	}
+	fmt.Fprintf(out, "}\n")
+	fmt.Fprintf(out, "\t_disabledLoose = make(map[string]bool)\n")
+	fmt.Fprintf(out, "\t_allSpied = false\n")
+	fmt.Fprintf(out, "\t_enabledSpies = make(map[string]bool)\n")
+	fmt.Fprintf(out, "\t_disabledSpies = make(map[string]bool)\n")
+	fmt.Fprintf(out, "\t_instanceMocks = make(map[interface{}]map[string]bool)\n")
+	fmt.Fprintf(out, "\t_funcVarTypes = make(map[string]_reflect.Type)\n")
+	fmt.Fprintf(out, "\t_nilFuncVars = make(map[string]_reflect.Value)\n")
+	fmt.Fprintf(out, "\t_expected = make(map[string]bool)\n")
+	fmt.Fprintf(out, "\t_calls []*_call\n")
+	fmt.Fprintf(out, "\t_tracer _traceT\n")
+	fmt.Fprintf(out, "\t_trace []*_traceEntry\n")
+	fmt.Fprintf(out, "\t_fixtureMode = _os.Getenv(\"WITHMOCK_FIXTURES\")\n")
+	fmt.Fprintf(out, "\t_fixtureDir = _os.Getenv(\"WITHMOCK_FIXTURE_DIR\")\n")
+	_, fixtureName := splitLabel(m.pkgName)
+	fmt.Fprintf(out, "\t_fixtureName = %q\n", fixtureName)
+	fmt.Fprintf(out, "\t_fixtureTest _fixtureT\n")
+	fmt.Fprintf(out, "\t_fixtureCalls []*_fixtureCall\n")
+	fmt.Fprintf(out, "\t_fixtureReplay map[string][]*_fixtureCall\n")
+	fmt.Fprintf(out, "\t_learned []string\n")
+	fmt.Fprintf(out, "\t_learnedRecv map[interface{}]string\n")
+	fmt.Fprintf(out, "\t_lock _sync.RWMutex\n")
 	fmt.Fprintf(out, "\t_ctrl *gomock.Controller\n")
 	fmt.Fprintf(out, "\t_pkgMock = &_packageMock{}\n")
 	fmt.Fprintf(out, ")\n\n")
 
+	fmt.Fprintf(out, "type _callRoute int\n\n")
+	fmt.Fprintf(out, "const (\n")
+	fmt.Fprintf(out, "\t_callReal _callRoute = iota\n")
+	fmt.Fprintf(out, "\t_callCtrl\n")
+	fmt.Fprintf(out, "\t_callZero\n")
+	fmt.Fprintf(out, "\t_callReplay\n")
+	fmt.Fprintf(out, ")\n\n")
+
+	fmt.Fprintf(out, "type _call struct {\n")
+	fmt.Fprintf(out, "\tName string\n")
+	fmt.Fprintf(out, "\tReceiver interface{}\n")
+	fmt.Fprintf(out, "\tArgs []interface{}\n")
+	fmt.Fprintf(out, "\tResults []interface{}\n")
+	fmt.Fprintf(out, "}\n\n")
+
+	// Trace entries store formatted values, so that they show the values at
+	// the time of the call, and can always be written out as JSON.
+	fmt.Fprintf(out, "type _traceEntry struct {\n")
+	fmt.Fprintf(out, "\tName string `json:\"name\"`\n")
+	fmt.Fprintf(out, "\tReceiver string `json:\"receiver,omitempty\"`\n")
+	fmt.Fprintf(out, "\tArgs []string `json:\"args\"`\n")
+	fmt.Fprintf(out, "\tResults []string `json:\"results\"`\n")
+	fmt.Fprintf(out, "\tRoute string `json:\"route\"`\n")
+	fmt.Fprintf(out, "\tGoroutine uint64 `json:\"goroutine\"`\n")
+	fmt.Fprintf(out, "}\n\n")
+
+	// Fixture results are stored as JSON, except for errors which are stored
+	// as {"error": "message"} since we can't rebuild the original error.
+	fmt.Fprintf(out, "type _fixtureCall struct {\n")
+	fmt.Fprintf(out, "\tName string `json:\"name\"`\n")
+	fmt.Fprintf(out, "\tArgs []string `json:\"args\"`\n")
+	fmt.Fprintf(out, "\tResults []_json.RawMessage `json:\"results\"`\n")
+	fmt.Fprintf(out, "}\n\n")
+
+	fmt.Fprintf(out, "type _fixtureError struct {\n")
+	fmt.Fprintf(out, "\tError string `json:\"error\"`\n")
+	fmt.Fprintf(out, "}\n\n")
+
+	fmt.Fprintf(out, "type _fixtureT interface {\n")
+	fmt.Fprintf(out, "\tName() string\n")
+	fmt.Fprintf(out, "\tLogf(format string, args ...interface{})\n")
+	fmt.Fprintf(out, "\tErrorf(format string, args ...interface{})\n")
+	fmt.Fprintf(out, "\tFatalf(format string, args ...interface{})\n")
+	fmt.Fprintf(out, "\tCleanup(func())\n")
+	fmt.Fprintf(out, "}\n\n")
+
+	fmt.Fprintf(out, "type _traceT interface {\n")
+	fmt.Fprintf(out, "\tFailed() bool\n")
+	fmt.Fprintf(out, "\tLogf(format string, args ...interface{})\n")
+	fmt.Fprintf(out, "}\n\n")
+
+	// _routeCall decides where a call should go, if it should be recorded in
+	// _calls for later inspection, and if it should be traced.  This is done
+	// for every call, so all of the state needed is read under a single read
+	// lock.
+	fmt.Fprintf(out, "func _routeCall(recv interface{}, name string) (_callRoute, bool, bool) {\n")
+	fmt.Fprintf(out, "\t_lock.RLock()\n")
+	fmt.Fprintf(out, "\tdefer _lock.RUnlock()\n")
+	fmt.Fprintf(out, "\troute, rec := _route(recv, name)\n")
+	fmt.Fprintf(out, "\treturn route, rec, _tracer != nil\n")
+	fmt.Fprintf(out, "}\n\n")
+
+	// _route does the work for _routeCall, and must be called with _lock held.
+	fmt.Fprintf(out, "func _route(recv interface{}, name string) (_callRoute, bool) {\n")
+	fmt.Fprintf(out, "\tif (_allSpied || _enabledSpies[name]) && !_disabledSpies[name] {\n")
+	fmt.Fprintf(out, "\t\treturn _callReal, true\n")
+	fmt.Fprintf(out, "\t}\n")
+	fmt.Fprintf(out, "\tif !_mocked(recv, name) {\n")
+	fmt.Fprintf(out, "\t\treturn _callReal, false\n")
+	fmt.Fprintf(out, "\t}\n")
+	fmt.Fprintf(out, "\tfixture := _fixtureTest != nil\n")
+	fmt.Fprintf(out, "\tif fixture && (_fixtureMode == \"record\" || _fixtureMode == \"learn\") {\n")
+	fmt.Fprintf(out, "\t\treturn _callReal, true\n")
+	fmt.Fprintf(out, "\t}\n")
+	fmt.Fprintf(out, "\tif fixture && _fixtureMode == \"replay\" {\n")
+	fmt.Fprintf(out, "\t\treturn _callReplay, false\n")
+	fmt.Fprintf(out, "\t}\n")
+	fmt.Fprintf(out, "\tif (!_allLoose && !_enabledLoose[name]) || _disabledLoose[name] || _expected[name] {\n")
+	fmt.Fprintf(out, "\t\treturn _ctrlRoute(name)\n")
+	fmt.Fprintf(out, "\t}\n")
+	fmt.Fprintf(out, "\tif _looseReal {\n")
+	fmt.Fprintf(out, "\t\treturn _callReal, true\n")
+	fmt.Fprintf(out, "\t}\n")
+	fmt.Fprintf(out, "\treturn _callZero, true\n")
+	fmt.Fprintf(out, "}\n\n")
+
+	// _mocked checks if mocking is enabled for name, settings for a specific
+	// instance take priority over the package wide settings.  Instances are
+	// only ever pointers, so the lookup can't hit an unhashable value.  Must
+	// be called with _lock held.
+	fmt.Fprintf(out, "func _mocked(recv interface{}, name string) bool {\n")
+	fmt.Fprintf(out, "\tif len(_instanceMocks) > 0 && recv != nil && _reflect.TypeOf(recv).Kind() == _reflect.Ptr {\n")
+	fmt.Fprintf(out, "\t\tnames := _instanceMocks[recv]\n")
+	fmt.Fprintf(out, "\t\tif enabled, found := names[name]; found {\n")
+	fmt.Fprintf(out, "\t\t\treturn enabled\n")
+	fmt.Fprintf(out, "\t\t}\n")
+	fmt.Fprintf(out, "\t\tif enabled, found := names[\"\"]; found {\n")
+	fmt.Fprintf(out, "\t\t\treturn enabled\n")
+	fmt.Fprintf(out, "\t\t}\n")
+	fmt.Fprintf(out, "\t}\n")
+	fmt.Fprintf(out, "\treturn (_allMocked || _enabledMocks[name]) && !_disabledMocks[name]\n")
+	fmt.Fprintf(out, "}\n\n")
+
+	fmt.Fprintf(out, "func _setInstanceMock(recv interface{}, enabled bool, names []string) {\n")
+	fmt.Fprintf(out, "\tif recv == nil || _reflect.TypeOf(recv).Kind() != _reflect.Ptr {\n")
+	fmt.Fprintf(out, "\t\tpanic(_fmt.Sprintf(\"withmock: unable to control mocking for instance of %%T (must be a pointer)\", recv))\n")
+	fmt.Fprintf(out, "\t}\n")
+	fmt.Fprintf(out, "\t_lock.Lock()\n")
+	fmt.Fprintf(out, "\tdefer _lock.Unlock()\n")
+	fmt.Fprintf(out, "\tif len(names) == 0 {\n")
+	fmt.Fprintf(out, "\t\t_instanceMocks[recv] = map[string]bool{\"\": enabled}\n")
+	fmt.Fprintf(out, "\t\treturn\n")
+	fmt.Fprintf(out, "\t}\n")
+	fmt.Fprintf(out, "\tif _instanceMocks[recv] == nil {\n")
+	fmt.Fprintf(out, "\t\t_instanceMocks[recv] = make(map[string]bool)\n")
+	fmt.Fprintf(out, "\t}\n")
+	fmt.Fprintf(out, "\tfor _, name := range names {\n")
+	fmt.Fprintf(out, "\t\t_instanceMocks[recv][name] = enabled\n")
+	fmt.Fprintf(out, "\t}\n")
+	fmt.Fprintf(out, "}\n\n")
+
+	// _ctrlRoute is used for calls that should go to the controller, which
+	// might not have been set yet.  Must be called with _lock held.
+	fmt.Fprintf(out, "func _ctrlRoute(name string) (_callRoute, bool) {\n")
+	fmt.Fprintf(out, "\tif _ctrl != nil {\n")
+	fmt.Fprintf(out, "\t\treturn _callCtrl, false\n")
+	fmt.Fprintf(out, "\t}\n")
+	fmt.Fprintf(out, "\tif _noCtrlReal {\n")
+	fmt.Fprintf(out, "\t\treturn _callReal, false\n")
+	fmt.Fprintf(out, "\t}\n")
+	fmt.Fprintf(out, "\tpanic(\"withmock: \" + name + \" called in mocked package %s before a controller was set (call %s().SetController first)\")\n", m.pkgName, m.MOCK)
+	fmt.Fprintf(out, "}\n\n")
+
+	// _ifaceCtrl returns the controller for the interface mocks, which can't
+	// call any real code, so no_controller doesn't apply to them.
+	fmt.Fprintf(out, "func _ifaceCtrl(name string) *gomock.Controller {\n")
+	fmt.Fprintf(out, "\t_lock.Lock()\n")
+	fmt.Fprintf(out, "\tdefer _lock.Unlock()\n")
+	fmt.Fprintf(out, "\tif _ctrl == nil {\n")
+	fmt.Fprintf(out, "\t\tpanic(\"withmock: \" + name + \" used in mocked package %s before a controller was set (call %s().SetController first, interface mocks have no real code to call instead)\")\n", m.pkgName, m.MOCK)
+	fmt.Fprintf(out, "\t}\n")
+	fmt.Fprintf(out, "\treturn _ctrl\n")
+	fmt.Fprintf(out, "}\n\n")
+
+	fmt.Fprintf(out, "func _expect(name string) {\n")
+	fmt.Fprintf(out, "\t_lock.Lock()\n")
+	fmt.Fprintf(out, "\tdefer _lock.Unlock()\n")
+	fmt.Fprintf(out, "\tif _ctrl == nil {\n")
+	fmt.Fprintf(out, "\t\tpanic(\"withmock: expectation for \" + name + \" set in mocked package %s before a controller was set (call %s().SetController first)\")\n", m.pkgName, m.MOCK)
+	fmt.Fprintf(out, "\t}\n")
+	fmt.Fprintf(out, "\t_expected[name] = true\n")
+	fmt.Fprintf(out, "}\n\n")
+
+	fmt.Fprintf(out, "func _recordCall(recv interface{}, name string, args, results []interface{}) {\n")
+	fmt.Fprintf(out, "\t_lock.Lock()\n")
+	fmt.Fprintf(out, "\tdefer _lock.Unlock()\n")
+	fmt.Fprintf(out, "\t_calls = append(_calls, &_call{name, recv, args, results})\n")
+	fmt.Fprintf(out, "\tif _fixtureTest != nil && _fixtureMode == \"learn\" {\n")
+	fmt.Fprintf(out, "\t\t_learned = append(_learned, _learnCall(recv, name, args, results)...)\n")
+	fmt.Fprintf(out, "\t}\n")
+	fmt.Fprintf(out, "\tif _fixtureTest == nil || _fixtureMode != \"record\" {\n")
+	fmt.Fprintf(out, "\t\treturn\n")
+	fmt.Fprintf(out, "\t}\n")
+	fmt.Fprintf(out, "\tcall := &_fixtureCall{Name: name, Args: _formatValues(args)}\n")
+	fmt.Fprintf(out, "\tfor _, result := range results {\n")
+	fmt.Fprintf(out, "\t\tif err, ok := result.(error); ok {\n")
+	fmt.Fprintf(out, "\t\t\tresult = _fixtureError{err.Error()}\n")
+	fmt.Fprintf(out, "\t\t}\n")
+	fmt.Fprintf(out, "\t\tdata, err := _json.Marshal(result)\n")
+	fmt.Fprintf(out, "\t\tif err != nil {\n")
+	fmt.Fprintf(out, "\t\t\t_fixtureTest.Errorf(\"withmock: unable to record result of %%s: %%s\", name, err)\n")
+	fmt.Fprintf(out, "\t\t\tdata = []byte(\"null\")\n")
+	fmt.Fprintf(out, "\t\t}\n")
+	fmt.Fprintf(out, "\t\tcall.Results = append(call.Results, data)\n")
+	fmt.Fprintf(out, "\t}\n")
+	fmt.Fprintf(out, "\t_fixtureCalls = append(_fixtureCalls, call)\n")
+	fmt.Fprintf(out, "}\n\n")
+
+	// _learnValue formats v as Go source, adding a conversion where the type
+	// would otherwise be lost (e.g. int64(1) instead of 1).  A whole float64
+	// gets a decimal point (1.0 instead of 1), so that it isn't an int.
+	fmt.Fprintf(out, "func _learnValue(v interface{}) string {\n")
+	fmt.Fprintf(out, "\tif v == nil {\n")
+	fmt.Fprintf(out, "\t\treturn \"nil\"\n")
+	fmt.Fprintf(out, "\t}\n")
+	fmt.Fprintf(out, "\tif err, ok := v.(error); ok {\n")
+	fmt.Fprintf(out, "\t\treturn _fmt.Sprintf(\"errors.New(%%q)\", err.Error())\n")
+	fmt.Fprintf(out, "\t}\n")
+	fmt.Fprintf(out, "\tt := _reflect.TypeOf(v)\n")
+	fmt.Fprintf(out, "\tswitch t.Kind() {\n")
+	fmt.Fprintf(out, "\tcase _reflect.Bool, _reflect.Int, _reflect.String:\n")
+	fmt.Fprintf(out, "\t\tif t.PkgPath() == \"\" {\n")
+	fmt.Fprintf(out, "\t\t\treturn _fmt.Sprintf(\"%%#v\", v)\n")
+	fmt.Fprintf(out, "\t\t}\n")
+	fmt.Fprintf(out, "\tcase _reflect.Float64:\n")
+	fmt.Fprintf(out, "\t\tif t.PkgPath() == \"\" {\n")
+	fmt.Fprintf(out, "\t\t\ts := _fmt.Sprintf(\"%%#v\", v)\n")
+	fmt.Fprintf(out, "\t\t\tif !_strings.ContainsAny(s, \".eEIN\") {\n")
+	fmt.Fprintf(out, "\t\t\t\ts += \".0\"\n")
+	fmt.Fprintf(out, "\t\t\t}\n")
+	fmt.Fprintf(out, "\t\t\treturn s\n")
+	fmt.Fprintf(out, "\t\t}\n")
+	fmt.Fprintf(out, "\tcase _reflect.Int8, _reflect.Int16, _reflect.Int32, _reflect.Int64,\n")
+	fmt.Fprintf(out, "\t\t_reflect.Uint, _reflect.Uint8, _reflect.Uint16, _reflect.Uint32,\n")
+	fmt.Fprintf(out, "\t\t_reflect.Uint64, _reflect.Uintptr, _reflect.Float32,\n")
+	fmt.Fprintf(out, "\t\t_reflect.Complex64, _reflect.Complex128:\n")
+	fmt.Fprintf(out, "\tdefault:\n")
+	fmt.Fprintf(out, "\t\treturn _fmt.Sprintf(\"%%#v\", v)\n")
+	fmt.Fprintf(out, "\t}\n")
+	fmt.Fprintf(out, "\treturn _fmt.Sprintf(\"%%T(%%#v)\", v, v)\n")
+	fmt.Fprintf(out, "}\n\n")
+
+	// _learnCall returns the EXPECT statement that reproduces a call, with a
+	// comment before the first use of each receiver.
+	fmt.Fprintf(out, "func _learnCall(recv interface{}, name string, args, results []interface{}) []string {\n")
+	fmt.Fprintf(out, "\tlines := []string{}\n")
+	fmt.Fprintf(out, "\ttarget := \"%s.%s()\"\n", name, m.EXPECT)
+	fmt.Fprintf(out, "\tif recv != nil {\n")
+	fmt.Fprintf(out, "\t\tcomparable := _reflect.TypeOf(recv).Comparable()\n")
+	fmt.Fprintf(out, "\t\tid, found := \"\", false\n")
+	fmt.Fprintf(out, "\t\tif comparable {\n")
+	fmt.Fprintf(out, "\t\t\tid, found = _learnedRecv[recv]\n")
+	fmt.Fprintf(out, "\t\t}\n")
+	fmt.Fprintf(out, "\t\tif !found {\n")
+	fmt.Fprintf(out, "\t\t\tid = _fmt.Sprintf(\"recv%%d\", len(_learnedRecv)+1)\n")
+	fmt.Fprintf(out, "\t\t\tif comparable {\n")
+	fmt.Fprintf(out, "\t\t\t\t_learnedRecv[recv] = id\n")
+	fmt.Fprintf(out, "\t\t\t}\n")
+	fmt.Fprintf(out, "\t\t\tlines = append(lines, _fmt.Sprintf(\"// %%s is %%#v\", id, recv))\n")
+	fmt.Fprintf(out, "\t\t}\n")
+	fmt.Fprintf(out, "\t\ttarget = id + \".%s()\"\n", m.ObjEXPECT)
+	fmt.Fprintf(out, "\t\tname = name[_strings.LastIndex(name, \".\")+1:]\n")
+	fmt.Fprintf(out, "\t}\n")
+	fmt.Fprintf(out, "\tstrs := make([]string, len(args))\n")
+	fmt.Fprintf(out, "\tfor i, arg := range args {\n")
+	fmt.Fprintf(out, "\t\tstrs[i] = _learnValue(arg)\n")
+	fmt.Fprintf(out, "\t}\n")
+	fmt.Fprintf(out, "\tline := target + \".\" + name + \"(\" + _strings.Join(strs, \", \") + \")\"\n")
+	fmt.Fprintf(out, "\tif len(results) > 0 {\n")
+	fmt.Fprintf(out, "\t\tstrs = make([]string, len(results))\n")
+	fmt.Fprintf(out, "\t\tfor i, result := range results {\n")
+	fmt.Fprintf(out, "\t\t\tstrs[i] = _learnValue(result)\n")
+	fmt.Fprintf(out, "\t\t}\n")
+	fmt.Fprintf(out, "\t\tline += \".Return(\" + _strings.Join(strs, \", \") + \")\"\n")
+	fmt.Fprintf(out, "\t}\n")
+	fmt.Fprintf(out, "\treturn append(lines, line)\n")
+	fmt.Fprintf(out, "}\n\n")
+
+	// _replayCall fills in the results for the next recorded call to name,
+	// with results being pointers to the result variables.
+	fmt.Fprintf(out, "func _replayCall(name string, results ...interface{}) {\n")
+	fmt.Fprintf(out, "\t_lock.Lock()\n")
+	fmt.Fprintf(out, "\tdefer _lock.Unlock()\n")
+	fmt.Fprintf(out, "\tcalls := _fixtureReplay[name]\n")
+	fmt.Fprintf(out, "\tif len(calls) == 0 {\n")
+	fmt.Fprintf(out, "\t\t_fixtureTest.Errorf(\"withmock: no more recorded calls to %%s\", name)\n")
+	fmt.Fprintf(out, "\t\treturn\n")
+	fmt.Fprintf(out, "\t}\n")
+	fmt.Fprintf(out, "\t_fixtureReplay[name] = calls[1:]\n")
+	fmt.Fprintf(out, "\tfor i, result := range results {\n")
+	fmt.Fprintf(out, "\t\tif i >= len(calls[0].Results) {\n")
+	fmt.Fprintf(out, "\t\t\tbreak\n")
+	fmt.Fprintf(out, "\t\t}\n")
+	fmt.Fprintf(out, "\t\tdata := calls[0].Results[i]\n")
+	fmt.Fprintf(out, "\t\tif errp, ok := result.(*error); ok {\n")
+	fmt.Fprintf(out, "\t\t\tvar e *_fixtureError\n")
+	fmt.Fprintf(out, "\t\t\tif err := _json.Unmarshal(data, &e); err == nil && e != nil {\n")
+	fmt.Fprintf(out, "\t\t\t\t*errp = _errors.New(e.Error)\n")
+	fmt.Fprintf(out, "\t\t\t}\n")
+	fmt.Fprintf(out, "\t\t\tcontinue\n")
+	fmt.Fprintf(out, "\t\t}\n")
+	fmt.Fprintf(out, "\t\tif err := _json.Unmarshal(data, result); err != nil {\n")
+	fmt.Fprintf(out, "\t\t\t_fixtureTest.Errorf(\"withmock: unable to replay result of %%s: %%s\", name, err)\n")
+	fmt.Fprintf(out, "\t\t}\n")
+	fmt.Fprintf(out, "\t}\n")
+	fmt.Fprintf(out, "}\n\n")
+
+	fmt.Fprintf(out, "func _formatValues(values []interface{}) []string {\n")
+	fmt.Fprintf(out, "\tstrs := make([]string, len(values))\n")
+	fmt.Fprintf(out, "\tfor i, value := range values {\n")
+	fmt.Fprintf(out, "\t\tstrs[i] = _fmt.Sprintf(\"%%#v\", value)\n")
+	fmt.Fprintf(out, "\t}\n")
+	fmt.Fprintf(out, "\treturn strs\n")
+	fmt.Fprintf(out, "}\n\n")
+
+	// The goroutine id is not available from the runtime, so we have to get
+	// it from the first line of the stack trace - "goroutine N [running]:".
+	fmt.Fprintf(out, "func _goroutine() uint64 {\n")
+	fmt.Fprintf(out, "\tbuf := make([]byte, 64)\n")
+	fmt.Fprintf(out, "\tfields := _strings.Fields(string(buf[:_runtime.Stack(buf, false)]))\n")
+	fmt.Fprintf(out, "\tif len(fields) < 2 {\n")
+	fmt.Fprintf(out, "\t\treturn 0\n")
+	fmt.Fprintf(out, "\t}\n")
+	fmt.Fprintf(out, "\tid, _ := _strconv.ParseUint(fields[1], 10, 64)\n")
+	fmt.Fprintf(out, "\treturn id\n")
+	fmt.Fprintf(out, "}\n\n")
+
+	fmt.Fprintf(out, "func _traceCall(recv interface{}, name string, route _callRoute, args, results []interface{}) {\n")
+	fmt.Fprintf(out, "\tentry := &_traceEntry{\n")
+	fmt.Fprintf(out, "\t\tName: name,\n")
+	fmt.Fprintf(out, "\t\tArgs: _formatValues(args),\n")
+	fmt.Fprintf(out, "\t\tResults: _formatValues(results),\n")
+	fmt.Fprintf(out, "\t\tRoute: [...]string{\"real\", \"mock\", \"zero\", \"replay\"}[route],\n")
+	fmt.Fprintf(out, "\t\tGoroutine: _goroutine(),\n")
+	fmt.Fprintf(out, "\t}\n")
+	fmt.Fprintf(out, "\tif recv != nil {\n")
+	fmt.Fprintf(out, "\t\tentry.Receiver = _fmt.Sprintf(\"%%#v\", recv)\n")
+	fmt.Fprintf(out, "\t}\n")
+	fmt.Fprintf(out, "\t_lock.Lock()\n")
+	fmt.Fprintf(out, "\tdefer _lock.Unlock()\n")
+	fmt.Fprintf(out, "\t_trace = append(_trace, entry)\n")
+	fmt.Fprintf(out, "}\n\n")
+
+	fmt.Fprintf(out, "func _methodType(method interface{}) _reflect.Type {\n")
+	fmt.Fprintf(out, "\treturn _reflect.TypeOf(method)\n")
+	fmt.Fprintf(out, "}\n\n")
+
+	// _funcVarType returns the type of a function variable, for the recorder.
+	// A variable that was nil when the package was initialised is mocked
+	// once an expectation is set for it.
+	fmt.Fprintf(out, "func _funcVarType(name string) _reflect.Type {\n")
+	fmt.Fprintf(out, "\tt, found := _funcVarTypes[name]\n")
+	fmt.Fprintf(out, "\tif !found {\n")
+	fmt.Fprintf(out, "\t\tpanic(\"withmock: %s.\" + name + \" does not hold a function\")\n", m.pkgName)
+	fmt.Fprintf(out, "\t}\n")
+	fmt.Fprintf(out, "\tif v, found := _nilFuncVars[name]; found {\n")
+	fmt.Fprintf(out, "\t\tdelete(_nilFuncVars, name)\n")
+	fmt.Fprintf(out, "\t\tif v.IsNil() {\n")
+	fmt.Fprintf(out, "\t\t\t_wrapFuncVar(name, v)\n")
+	fmt.Fprintf(out, "\t\t}\n")
+	fmt.Fprintf(out, "\t}\n")
+	fmt.Fprintf(out, "\treturn t\n")
+	fmt.Fprintf(out, "}\n\n")
+
+	// _mockFuncVar records the declared type of the variable pointed to by
+	// ptr, and replaces the function in it with one that routes calls in the
+	// same way as a mocked function.  A nil variable is left alone (so that
+	// nil checks still work) until an expectation is set.
+	fmt.Fprintf(out, "func _mockFuncVar(name string, ptr interface{}) {\n")
+	fmt.Fprintf(out, "\tv := _reflect.ValueOf(ptr).Elem()\n")
+	fmt.Fprintf(out, "\tif v.Kind() != _reflect.Func {\n")
+	fmt.Fprintf(out, "\t\treturn\n")
+	fmt.Fprintf(out, "\t}\n")
+	fmt.Fprintf(out, "\t_funcVarTypes[name] = v.Type()\n")
+	fmt.Fprintf(out, "\tif v.IsNil() {\n")
+	fmt.Fprintf(out, "\t\t_nilFuncVars[name] = v\n")
+	fmt.Fprintf(out, "\t\treturn\n")
+	fmt.Fprintf(out, "\t}\n")
+	fmt.Fprintf(out, "\t_wrapFuncVar(name, v)\n")
+	fmt.Fprintf(out, "}\n\n")
+
+	fmt.Fprintf(out, "func _wrapFuncVar(name string, v _reflect.Value) {\n")
+	fmt.Fprintf(out, "\treal := _reflect.ValueOf(v.Interface())\n")
+	fmt.Fprintf(out, "\tv.Set(_reflect.MakeFunc(v.Type(), func(in []_reflect.Value) []_reflect.Value {\n")
+	fmt.Fprintf(out, "\t\treturn _callFuncVar(name, real, in)\n")
+	fmt.Fprintf(out, "\t}))\n")
+	fmt.Fprintf(out, "}\n\n")
+
+	fmt.Fprintf(out, "func _callFuncVar(name string, real _reflect.Value, in []_reflect.Value) []_reflect.Value {\n")
+	fmt.Fprintf(out, "\tt := real.Type()\n")
+	fmt.Fprintf(out, "\tcallReal := func() []_reflect.Value {\n")
+	fmt.Fprintf(out, "\t\tif real.IsNil() {\n")
+	fmt.Fprintf(out, "\t\t\tpanic(\"withmock: %s.\" + name + \" was nil, so there is no real function to call\")\n", m.pkgName)
+	fmt.Fprintf(out, "\t\t}\n")
+	fmt.Fprintf(out, "\t\tif t.IsVariadic() {\n")
+	fmt.Fprintf(out, "\t\t\treturn real.CallSlice(in)\n")
+	fmt.Fprintf(out, "\t\t}\n")
+	fmt.Fprintf(out, "\t\treturn real.Call(in)\n")
+	fmt.Fprintf(out, "\t}\n")
+	fmt.Fprintf(out, "\t_r, _rec, _tr := _routeCall(nil, name)\n")
+	fmt.Fprintf(out, "\tif _r == _callReal && !_rec && !_tr {\n")
+	fmt.Fprintf(out, "\t\treturn callReal()\n")
+	fmt.Fprintf(out, "\t}\n")
+	fmt.Fprintf(out, "\targs := []interface{}{}\n")
+	fmt.Fprintf(out, "\tfor i, arg := range in {\n")
+	fmt.Fprintf(out, "\t\tif t.IsVariadic() && i == len(in)-1 {\n")
+	fmt.Fprintf(out, "\t\t\tfor j := 0; j < arg.Len(); j++ {\n")
+	fmt.Fprintf(out, "\t\t\t\targs = append(args, arg.Index(j).Interface())\n")
+	fmt.Fprintf(out, "\t\t\t}\n")
+	fmt.Fprintf(out, "\t\t\tcontinue\n")
+	fmt.Fprintf(out, "\t\t}\n")
+	fmt.Fprintf(out, "\t\targs = append(args, arg.Interface())\n")
+	fmt.Fprintf(out, "\t}\n")
+	fmt.Fprintf(out, "\tout := make([]_reflect.Value, t.NumOut())\n")
+	fmt.Fprintf(out, "\tret := make([]interface{}, t.NumOut())\n")
+	fmt.Fprintf(out, "\tswitch _r {\n")
+	fmt.Fprintf(out, "\tcase _callReal:\n")
+	fmt.Fprintf(out, "\t\tout = callReal()\n")
+	fmt.Fprintf(out, "\t\tfor i := range out {\n")
+	fmt.Fprintf(out, "\t\t\tret[i] = out[i].Interface()\n")
+	fmt.Fprintf(out, "\t\t}\n")
+	fmt.Fprintf(out, "\tcase _callCtrl:\n")
+	fmt.Fprintf(out, "\t\tret = _ctrl.Call(_pkgMock, name, args...)\n")
+	fmt.Fprintf(out, "\tcase _callReplay:\n")
+	fmt.Fprintf(out, "\t\tresults := make([]interface{}, len(ret))\n")
+	fmt.Fprintf(out, "\t\tfor i := range results {\n")
+	fmt.Fprintf(out, "\t\t\tresults[i] = _reflect.New(t.Out(i)).Interface()\n")
+	fmt.Fprintf(out, "\t\t}\n")
+	fmt.Fprintf(out, "\t\t_replayCall(name, results...)\n")
+	fmt.Fprintf(out, "\t\tfor i := range results {\n")
+	fmt.Fprintf(out, "\t\t\tret[i] = _reflect.ValueOf(results[i]).Elem().Interface()\n")
+	fmt.Fprintf(out, "\t\t}\n")
+	fmt.Fprintf(out, "\t}\n")
+	fmt.Fprintf(out, "\tif _rec {\n")
+	fmt.Fprintf(out, "\t\t_recordCall(nil, name, args, ret)\n")
+	fmt.Fprintf(out, "\t}\n")
+	fmt.Fprintf(out, "\tif _tr {\n")
+	fmt.Fprintf(out, "\t\t_traceCall(nil, name, _r, args, ret)\n")
+	fmt.Fprintf(out, "\t}\n")
+	fmt.Fprintf(out, "\tif _r == _callReal {\n")
+	fmt.Fprintf(out, "\t\treturn out\n")
+	fmt.Fprintf(out, "\t}\n")
+	fmt.Fprintf(out, "\tfor i := range out {\n")
+	fmt.Fprintf(out, "\t\tout[i] = _reflect.New(t.Out(i)).Elem()\n")
+	fmt.Fprintf(out, "\t\tif i >= len(ret) || ret[i] == nil {\n")
+	fmt.Fprintf(out, "\t\t\tcontinue\n")
+	fmt.Fprintf(out, "\t\t}\n")
+	fmt.Fprintf(out, "\t\tv := _reflect.ValueOf(ret[i])\n")
+	fmt.Fprintf(out, "\t\tif !v.Type().AssignableTo(t.Out(i)) {\n")
+	fmt.Fprintf(out, "\t\t\t_ctrl.T.Fatalf(\"%%s: return value %%d has type %%T, expected %%s\", name, i, ret[i], t.Out(i))\n")
+	fmt.Fprintf(out, "\t\t}\n")
+	fmt.Fprintf(out, "\t\tout[i].Set(v)\n")
+	fmt.Fprintf(out, "\t}\n")
+	fmt.Fprintf(out, "\treturn out\n")
+	fmt.Fprintf(out, "}\n\n")
+
+	// callInits runs the original init functions with mocking disabled.  The
+	// lock can't be held while they run, as they may call mocked functions.
 	fmt.Fprintf(out, "func callInits(inits ...func()) {\n")
+	fmt.Fprintf(out, "\t_lock.Lock()\n")
 	fmt.Fprintf(out, "\tmocked := _allMocked\n")
 	fmt.Fprintf(out, "\tenabledMocks := _enabledMocks\n")
 	fmt.Fprintf(out, "\t_allMocked = false\n")
 	fmt.Fprintf(out, "\t_enabledMocks = nil\n")
+	fmt.Fprintf(out, "\t_lock.Unlock()\n")
 	fmt.Fprintf(out, "\tfor _, f := range inits {\n")
 	fmt.Fprintf(out, "\t\tf()\n")
 	fmt.Fprintf(out, "\t}\n")
+	fmt.Fprintf(out, "\t_lock.Lock()\n")
 	fmt.Fprintf(out, "\t_allMocked = mocked\n")
 	fmt.Fprintf(out, "\t_enabledMocks = enabledMocks\n")
+	fmt.Fprintf(out, "\t_lock.Unlock()\n")
 	fmt.Fprintf(out, "}\n\n")
 
 	fmt.Fprintf(out, "func %s() *_meta {\n", m.MOCK)
@@ -963,16 +1898,25 @@ func (m *mockGen) pkg(out io.Writer, name string) error {
 	fmt.Fprintf(out, "}\n")
 
 	fmt.Fprintf(out, "func (_ *_meta) SetController(controller *gomock.Controller) {\n")
+	fmt.Fprintf(out, "\t_lock.Lock()\n")
+	fmt.Fprintf(out, "\tdefer _lock.Unlock()\n")
 	fmt.Fprintf(out, "\t_ctrl = controller\n")
+	fmt.Fprintf(out, "\t_expected = make(map[string]bool)\n")
+	fmt.Fprintf(out, "\t_calls = nil\n")
 	fmt.Fprintf(out, "}\n")
 
 	fmt.Fprintf(out, "func (_ *_meta) MockAll(enabled bool) {\n")
+	fmt.Fprintf(out, "\t_lock.Lock()\n")
+	fmt.Fprintf(out, "\tdefer _lock.Unlock()\n")
 	fmt.Fprintf(out, "\t_allMocked = enabled\n")
 	fmt.Fprintf(out, "\t_enabledMocks = make(map[string]bool)\n")
 	fmt.Fprintf(out, "\t_disabledMocks = make(map[string]bool)\n")
+	fmt.Fprintf(out, "\t_instanceMocks = make(map[interface{}]map[string]bool)\n")
 	fmt.Fprintf(out, "}\n")
 
 	fmt.Fprintf(out, "func (_ *_meta) EnableMock(names ...string) {\n")
+	fmt.Fprintf(out, "\t_lock.Lock()\n")
+	fmt.Fprintf(out, "\tdefer _lock.Unlock()\n")
 	fmt.Fprintf(out, "\tfor _, name := range names {\n")
 	fmt.Fprintf(out, "\t\t_enabledMocks[name] = true\n")
 	fmt.Fprintf(out, "\t\tdelete(_disabledMocks, name)\n")
@@ -980,16 +1924,308 @@ func (m *mockGen) pkg(out io.Writer, name string) error {
 	fmt.Fprintf(out, "}\n\n")
 
 	fmt.Fprintf(out, "func (_ *_meta) DisableMock(names ...string) {\n")
+	fmt.Fprintf(out, "\t_lock.Lock()\n")
+	fmt.Fprintf(out, "\tdefer _lock.Unlock()\n")
 	fmt.Fprintf(out, "\tfor _, name := range names {\n")
 	fmt.Fprintf(out, "\t\t_disabledMocks[name] = true\n")
 	fmt.Fprintf(out, "\t\tdelete(_enabledMocks, name)\n")
 	fmt.Fprintf(out, "\t}\n")
 	fmt.Fprintf(out, "}\n\n")
 
+	fmt.Fprintf(out, "func (_ *_meta) EnableMockFor(recv interface{}, names ...string) {\n")
+	fmt.Fprintf(out, "\t_setInstanceMock(recv, true, names)\n")
+	fmt.Fprintf(out, "}\n\n")
+
+	fmt.Fprintf(out, "func (_ *_meta) DisableMockFor(recv interface{}, names ...string) {\n")
+	fmt.Fprintf(out, "\t_setInstanceMock(recv, false, names)\n")
+	fmt.Fprintf(out, "}\n\n")
+
+	fmt.Fprintf(out, "func (_ *_meta) LooseAll(enabled bool) {\n")
+	fmt.Fprintf(out, "\t_lock.Lock()\n")
+	fmt.Fprintf(out, "\tdefer _lock.Unlock()\n")
+	fmt.Fprintf(out, "\t_allLoose = enabled\n")
+	fmt.Fprintf(out, "\t_enabledLoose = make(map[string]bool)\n")
+	fmt.Fprintf(out, "\t_disabledLoose = make(map[string]bool)\n")
+	fmt.Fprintf(out, "}\n")
+
+	fmt.Fprintf(out, "func (_ *_meta) EnableLoose(names ...string) {\n")
+	fmt.Fprintf(out, "\t_lock.Lock()\n")
+	fmt.Fprintf(out, "\tdefer _lock.Unlock()\n")
+	fmt.Fprintf(out, "\tfor _, name := range names {\n")
+	fmt.Fprintf(out, "\t\t_enabledLoose[name] = true\n")
+	fmt.Fprintf(out, "\t\tdelete(_disabledLoose, name)\n")
+	fmt.Fprintf(out, "\t}\n")
+	fmt.Fprintf(out, "}\n\n")
+
+	fmt.Fprintf(out, "func (_ *_meta) DisableLoose(names ...string) {\n")
+	fmt.Fprintf(out, "\t_lock.Lock()\n")
+	fmt.Fprintf(out, "\tdefer _lock.Unlock()\n")
+	fmt.Fprintf(out, "\tfor _, name := range names {\n")
+	fmt.Fprintf(out, "\t\t_disabledLoose[name] = true\n")
+	fmt.Fprintf(out, "\t\tdelete(_enabledLoose, name)\n")
+	fmt.Fprintf(out, "\t}\n")
+	fmt.Fprintf(out, "}\n\n")
+
+	fmt.Fprintf(out, "func (_ *_meta) LooseReal(enabled bool) {\n")
+	fmt.Fprintf(out, "\t_lock.Lock()\n")
+	fmt.Fprintf(out, "\tdefer _lock.Unlock()\n")
+	fmt.Fprintf(out, "\t_looseReal = enabled\n")
+	fmt.Fprintf(out, "}\n")
+
+	fmt.Fprintf(out, "func (_ *_meta) SpyAll(enabled bool) {\n")
+	fmt.Fprintf(out, "\t_lock.Lock()\n")
+	fmt.Fprintf(out, "\tdefer _lock.Unlock()\n")
+	fmt.Fprintf(out, "\t_allSpied = enabled\n")
+	fmt.Fprintf(out, "\t_enabledSpies = make(map[string]bool)\n")
+	fmt.Fprintf(out, "\t_disabledSpies = make(map[string]bool)\n")
+	fmt.Fprintf(out, "}\n")
+
+	fmt.Fprintf(out, "func (_ *_meta) EnableSpy(names ...string) {\n")
+	fmt.Fprintf(out, "\t_lock.Lock()\n")
+	fmt.Fprintf(out, "\tdefer _lock.Unlock()\n")
+	fmt.Fprintf(out, "\tfor _, name := range names {\n")
+	fmt.Fprintf(out, "\t\t_enabledSpies[name] = true\n")
+	fmt.Fprintf(out, "\t\tdelete(_disabledSpies, name)\n")
+	fmt.Fprintf(out, "\t}\n")
+	fmt.Fprintf(out, "}\n\n")
+
+	fmt.Fprintf(out, "func (_ *_meta) DisableSpy(names ...string) {\n")
+	fmt.Fprintf(out, "\t_lock.Lock()\n")
+	fmt.Fprintf(out, "\tdefer _lock.Unlock()\n")
+	fmt.Fprintf(out, "\tfor _, name := range names {\n")
+	fmt.Fprintf(out, "\t\t_disabledSpies[name] = true\n")
+	fmt.Fprintf(out, "\t\tdelete(_enabledSpies, name)\n")
+	fmt.Fprintf(out, "\t}\n")
+	fmt.Fprintf(out, "}\n\n")
+
+	fmt.Fprintf(out, "func (_ *_meta) Calls(name string) []*_call {\n")
+	fmt.Fprintf(out, "\t_lock.Lock()\n")
+	fmt.Fprintf(out, "\tdefer _lock.Unlock()\n")
+	fmt.Fprintf(out, "\tcalls := []*_call{}\n")
+	fmt.Fprintf(out, "\tfor _, call := range _calls {\n")
+	fmt.Fprintf(out, "\t\tif call.Name == name {\n")
+	fmt.Fprintf(out, "\t\t\tcalls = append(calls, call)\n")
+	fmt.Fprintf(out, "\t\t}\n")
+	fmt.Fprintf(out, "\t}\n")
+	fmt.Fprintf(out, "\treturn calls\n")
+	fmt.Fprintf(out, "}\n")
+
+	// Called reports if a recorded call matches the given arguments, which can
+	// be either values or gomock Matchers.
+	fmt.Fprintf(out, "func (_m *_meta) Called(name string, args ...interface{}) bool {\n")
+	fmt.Fprintf(out, "\tfor _, call := range _m.Calls(name) {\n")
+	fmt.Fprintf(out, "\t\tif len(call.Args) != len(args) {\n")
+	fmt.Fprintf(out, "\t\t\tcontinue\n")
+	fmt.Fprintf(out, "\t\t}\n")
+	fmt.Fprintf(out, "\t\tmatched := true\n")
+	fmt.Fprintf(out, "\t\tfor i, arg := range args {\n")
+	fmt.Fprintf(out, "\t\t\tm, ok := arg.(gomock.Matcher)\n")
+	fmt.Fprintf(out, "\t\t\tif !ok {\n")
+	fmt.Fprintf(out, "\t\t\t\tm = gomock.Eq(arg)\n")
+	fmt.Fprintf(out, "\t\t\t}\n")
+	fmt.Fprintf(out, "\t\t\tif !m.Matches(call.Args[i]) {\n")
+	fmt.Fprintf(out, "\t\t\t\tmatched = false\n")
+	fmt.Fprintf(out, "\t\t\t\tbreak\n")
+	fmt.Fprintf(out, "\t\t\t}\n")
+	fmt.Fprintf(out, "\t\t}\n")
+	fmt.Fprintf(out, "\t\tif matched {\n")
+	fmt.Fprintf(out, "\t\t\treturn true\n")
+	fmt.Fprintf(out, "\t\t}\n")
+	fmt.Fprintf(out, "\t}\n")
+	fmt.Fprintf(out, "\treturn false\n")
+	fmt.Fprintf(out, "}\n")
+
+	fmt.Fprintf(out, "func (_ *_meta) ResetCalls() {\n")
+	fmt.Fprintf(out, "\t_lock.Lock()\n")
+	fmt.Fprintf(out, "\tdefer _lock.Unlock()\n")
+	fmt.Fprintf(out, "\t_calls = nil\n")
+	fmt.Fprintf(out, "}\n\n")
+
+	// Trace starts recording a trace of all calls for the test t, if t has a
+	// Cleanup method then the trace will be logged if the test fails.
+	fmt.Fprintf(out, "func (_m *_meta) Trace(t _traceT) {\n")
+	fmt.Fprintf(out, "\t_lock.Lock()\n")
+	fmt.Fprintf(out, "\t_tracer = t\n")
+	fmt.Fprintf(out, "\t_trace = nil\n")
+	fmt.Fprintf(out, "\t_lock.Unlock()\n")
+	fmt.Fprintf(out, "\tif c, ok := t.(interface{ Cleanup(func()) }); ok {\n")
+	fmt.Fprintf(out, "\t\tc.Cleanup(func() {\n")
+	fmt.Fprintf(out, "\t\t\tif t.Failed() {\n")
+	fmt.Fprintf(out, "\t\t\t\tbuf := &_strings.Builder{}\n")
+	fmt.Fprintf(out, "\t\t\t\t_m.WriteTrace(buf)\n")
+	fmt.Fprintf(out, "\t\t\t\tt.Logf(\"withmock call trace:\\n%%s\", buf)\n")
+	fmt.Fprintf(out, "\t\t\t}\n")
+	fmt.Fprintf(out, "\t\t\t_m.StopTrace()\n")
+	fmt.Fprintf(out, "\t\t})\n")
+	fmt.Fprintf(out, "\t}\n")
+	fmt.Fprintf(out, "}\n\n")
+
+	// _testPackage returns the import path of the package containing the test
+	// function for the test called name, falling back to the package that
+	// called Fixture.  The package under test is built using a label, which
+	// is removed to give the real import path.
+	fmt.Fprintf(out, "func _testPackage(name string) string {\n")
+	fmt.Fprintf(out, "\ttest := _strings.SplitN(name, \"/\", 2)[0]\n")
+	fmt.Fprintf(out, "\tpcs := make([]uintptr, 64)\n")
+	fmt.Fprintf(out, "\tframes := _runtime.CallersFrames(pcs[:_runtime.Callers(3, pcs)])\n")
+	fmt.Fprintf(out, "\tcaller := \"\"\n")
+	fmt.Fprintf(out, "\tfor {\n")
+	fmt.Fprintf(out, "\t\tframe, more := frames.Next()\n")
+	fmt.Fprintf(out, "\t\tfn := frame.Function\n")
+	fmt.Fprintf(out, "\t\tslash := _strings.LastIndex(fn, \"/\") + 1\n")
+	fmt.Fprintf(out, "\t\tif dot := _strings.Index(fn[slash:], \".\"); dot >= 0 {\n")
+	fmt.Fprintf(out, "\t\t\tpkg, rest := fn[:slash+dot], fn[slash+dot+1:]\n")
+	fmt.Fprintf(out, "\t\t\tpkg = _strings.Replace(pkg, \"%%2e\", \".\", -1)\n")
+	fmt.Fprintf(out, "\t\t\tif parts := _strings.SplitN(pkg, \"/\", 3); len(parts) == 3 && parts[0] == %q {\n", labelRoot)
+	fmt.Fprintf(out, "\t\t\t\tpkg = parts[2]\n")
+	fmt.Fprintf(out, "\t\t\t}\n")
+	fmt.Fprintf(out, "\t\t\tif caller == \"\" {\n")
+	fmt.Fprintf(out, "\t\t\t\tcaller = pkg\n")
+	fmt.Fprintf(out, "\t\t\t}\n")
+	fmt.Fprintf(out, "\t\t\tif rest == test || _strings.HasPrefix(rest, test+\".\") {\n")
+	fmt.Fprintf(out, "\t\t\t\treturn pkg\n")
+	fmt.Fprintf(out, "\t\t\t}\n")
+	fmt.Fprintf(out, "\t\t}\n")
+	fmt.Fprintf(out, "\t\tif !more {\n")
+	fmt.Fprintf(out, "\t\t\treturn caller\n")
+	fmt.Fprintf(out, "\t\t}\n")
+	fmt.Fprintf(out, "\t}\n")
+	fmt.Fprintf(out, "}\n\n")
+
+	// _fixturePath returns the fixture file for this package in test t.  The
+	// import paths and test name are kept as directories, so that fixtures
+	// from different packages can't collide.
+	fmt.Fprintf(out, "func _fixturePath(t _fixtureT, testPkg, ext string) string {\n")
+	fmt.Fprintf(out, "\treturn _filepath.Join(_fixtureDir, _filepath.FromSlash(testPkg),\n")
+	fmt.Fprintf(out, "\t\t_filepath.FromSlash(t.Name()), _filepath.FromSlash(_fixtureName)+ext)\n")
+	fmt.Fprintf(out, "}\n\n")
+
+	// Fixture enables record, replay or learning of calls for the test t,
+	// depending on how withmock was run.  Without a fixture mode it does
+	// nothing.
+	fmt.Fprintf(out, "func (_ *_meta) Fixture(t _fixtureT) {\n")
+	fmt.Fprintf(out, "\tif _fixtureMode == \"\" {\n")
+	fmt.Fprintf(out, "\t\treturn\n")
+	fmt.Fprintf(out, "\t}\n")
+	fmt.Fprintf(out, "\tpath := _fixturePath(t, _testPackage(t.Name()), \".json\")\n")
+	fmt.Fprintf(out, "\treplay := make(map[string][]*_fixtureCall)\n")
+	fmt.Fprintf(out, "\tif _fixtureMode == \"replay\" {\n")
+	fmt.Fprintf(out, "\t\tdata, err := _ioutil.ReadFile(path)\n")
+	fmt.Fprintf(out, "\t\tif err != nil {\n")
+	fmt.Fprintf(out, "\t\t\tt.Fatalf(\"withmock: unable to read fixture: %%s\", err)\n")
+	fmt.Fprintf(out, "\t\t}\n")
+	fmt.Fprintf(out, "\t\tcalls := []*_fixtureCall{}\n")
+	fmt.Fprintf(out, "\t\tif err := _json.Unmarshal(data, &calls); err != nil {\n")
+	fmt.Fprintf(out, "\t\t\tt.Fatalf(\"withmock: unable to parse fixture %%s: %%s\", path, err)\n")
+	fmt.Fprintf(out, "\t\t}\n")
+	fmt.Fprintf(out, "\t\tfor _, call := range calls {\n")
+	fmt.Fprintf(out, "\t\t\treplay[call.Name] = append(replay[call.Name], call)\n")
+	fmt.Fprintf(out, "\t\t}\n")
+	fmt.Fprintf(out, "\t}\n")
+	fmt.Fprintf(out, "\t_lock.Lock()\n")
+	fmt.Fprintf(out, "\t_fixtureTest = t\n")
+	fmt.Fprintf(out, "\t_fixtureCalls = nil\n")
+	fmt.Fprintf(out, "\t_fixtureReplay = replay\n")
+	fmt.Fprintf(out, "\t_learned = nil\n")
+	fmt.Fprintf(out, "\t_learnedRecv = make(map[interface{}]string)\n")
+	fmt.Fprintf(out, "\t_lock.Unlock()\n")
+	fmt.Fprintf(out, "\tt.Cleanup(func() {\n")
+	fmt.Fprintf(out, "\t\t_lock.Lock()\n")
+	fmt.Fprintf(out, "\t\tcalls := _fixtureCalls\n")
+	fmt.Fprintf(out, "\t\tlearned := _learned\n")
+	fmt.Fprintf(out, "\t\t_fixtureTest = nil\n")
+	fmt.Fprintf(out, "\t\t_lock.Unlock()\n")
+	fmt.Fprintf(out, "\t\tvar data []byte\n")
+	fmt.Fprintf(out, "\t\tvar err error\n")
+	fmt.Fprintf(out, "\t\tswitch _fixtureMode {\n")
+	fmt.Fprintf(out, "\t\tcase \"record\":\n")
+	fmt.Fprintf(out, "\t\t\tif calls == nil {\n")
+	fmt.Fprintf(out, "\t\t\t\tcalls = []*_fixtureCall{}\n")
+	fmt.Fprintf(out, "\t\t\t}\n")
+	fmt.Fprintf(out, "\t\t\tdata, err = _json.MarshalIndent(calls, \"\", \"  \")\n")
+	fmt.Fprintf(out, "\t\tcase \"learn\":\n")
+	fmt.Fprintf(out, "\t\t\tpath = _strings.TrimSuffix(path, \".json\") + \".expect\"\n")
+	fmt.Fprintf(out, "\t\t\ttext := _strings.Join(learned, \"\\n\")\n")
+	fmt.Fprintf(out, "\t\t\tt.Logf(\"withmock learned expectations:\\n%%s\", text)\n")
+	fmt.Fprintf(out, "\t\t\tdata = []byte(text + \"\\n\")\n")
+	fmt.Fprintf(out, "\t\tdefault:\n")
+	fmt.Fprintf(out, "\t\t\treturn\n")
+	fmt.Fprintf(out, "\t\t}\n")
+	fmt.Fprintf(out, "\t\tif err == nil {\n")
+	fmt.Fprintf(out, "\t\t\terr = _os.MkdirAll(_filepath.Dir(path), 0755)\n")
+	fmt.Fprintf(out, "\t\t}\n")
+	fmt.Fprintf(out, "\t\tif err == nil {\n")
+	fmt.Fprintf(out, "\t\t\terr = _ioutil.WriteFile(path, data, 0644)\n")
+	fmt.Fprintf(out, "\t\t}\n")
+	fmt.Fprintf(out, "\t\tif err != nil {\n")
+	fmt.Fprintf(out, "\t\t\tt.Errorf(\"withmock: unable to write fixture: %%s\", err)\n")
+	fmt.Fprintf(out, "\t\t}\n")
+	fmt.Fprintf(out, "\t})\n")
+	fmt.Fprintf(out, "}\n\n")
+
+	fmt.Fprintf(out, "func (_ *_meta) StopTrace() {\n")
+	fmt.Fprintf(out, "\t_lock.Lock()\n")
+	fmt.Fprintf(out, "\tdefer _lock.Unlock()\n")
+	fmt.Fprintf(out, "\t_tracer = nil\n")
+	fmt.Fprintf(out, "}\n\n")
+
+	fmt.Fprintf(out, "func (_ *_meta) TraceCalls() []*_traceEntry {\n")
+	fmt.Fprintf(out, "\t_lock.Lock()\n")
+	fmt.Fprintf(out, "\tdefer _lock.Unlock()\n")
+	fmt.Fprintf(out, "\treturn append([]*_traceEntry{}, _trace...)\n")
+	fmt.Fprintf(out, "}\n\n")
+
+	fmt.Fprintf(out, "func (_m *_meta) WriteTrace(w _io.Writer) error {\n")
+	fmt.Fprintf(out, "\tfor _, entry := range _m.TraceCalls() {\n")
+	fmt.Fprintf(out, "\t\ton := \"\"\n")
+	fmt.Fprintf(out, "\t\tif entry.Receiver != \"\" {\n")
+	fmt.Fprintf(out, "\t\t\ton = \" on \" + entry.Receiver\n")
+	fmt.Fprintf(out, "\t\t}\n")
+	fmt.Fprintf(out, "\t\t_, err := _fmt.Fprintf(w, \"[goroutine %%d] %%s %%s(%%s)%%s = (%%s)\\n\",\n")
+	fmt.Fprintf(out, "\t\t\tentry.Goroutine, entry.Route, entry.Name,\n")
+	fmt.Fprintf(out, "\t\t\t_strings.Join(entry.Args, \", \"), on,\n")
+	fmt.Fprintf(out, "\t\t\t_strings.Join(entry.Results, \", \"))\n")
+	fmt.Fprintf(out, "\t\tif err != nil {\n")
+	fmt.Fprintf(out, "\t\t\treturn err\n")
+	fmt.Fprintf(out, "\t\t}\n")
+	fmt.Fprintf(out, "\t}\n")
+	fmt.Fprintf(out, "\treturn nil\n")
+	fmt.Fprintf(out, "}\n\n")
+
+	fmt.Fprintf(out, "func (_m *_meta) WriteTraceJSON(w _io.Writer) error {\n")
+	fmt.Fprintf(out, "\tenc := _json.NewEncoder(w)\n")
+	fmt.Fprintf(out, "\tenc.SetIndent(\"\", \"  \")\n")
+	fmt.Fprintf(out, "\treturn enc.Encode(_m.TraceCalls())\n")
+	fmt.Fprintf(out, "}\n\n")
+
 	fmt.Fprintf(out, "func %s() *_package_Rec {\n", m.EXPECT)
 	fmt.Fprintf(out, "\treturn &_package_Rec{_pkgMock}\n")
 	fmt.Fprintf(out, "}\n\n")
 
+	// Recorders for unexported functions and methods are reached through
+	// MOCK().Unexported(), since tests in other packages can't call
+	// unexported recorder methods.
+	fmt.Fprintf(out, "type _package_URec struct {\n")
+	fmt.Fprintf(out, "\tmock *_packageMock\n")
+	fmt.Fprintf(out, "}\n\n")
+	fmt.Fprintf(out, "func (_ *_meta) Unexported() *_package_URec {\n")
+	fmt.Fprintf(out, "\treturn &_package_URec{_pkgMock}\n")
+	fmt.Fprintf(out, "}\n\n")
+
+	accessors := m.unexportedAccessors()
+	for base, urec := range m.unexportedRecs {
+		if _, found := m.unexportedRecs[base[1:]]; base[0] == '*' && found {
+			// If pointer and non-pointer receiver, just use the non-pointer
+			continue
+		}
+		writeUnexportedRecorder(out, base, urec, accessors[urec])
+	}
+
+	fmt.Fprintf(out, "type _package_Real struct{}\n\n")
+	fmt.Fprintf(out, "func (_ *_meta) Real() *_package_Real {\n")
+	fmt.Fprintf(out, "\treturn &_package_Real{}\n")
+	fmt.Fprintf(out, "}\n\n")
+
 	for base, rec := range m.recorders {
 		if _, found := m.recorders[base[1:]]; base[0] == '*' && found {
 			// If pointer and non-pointer receiver, just use the non-pointer
@@ -1021,11 +2257,110 @@ func (m *mockGen) pkg(out io.Writer, name string) error {
 		fmt.Fprintf(out, "func (_m %s) %s() *%s {\n", base, m.ObjEXPECT, rec)
 		fmt.Fprintf(out, "\treturn &%s{_m}\n", rec)
 		fmt.Fprintf(out, "}\n\n")
+		real := realAccessor(rec)
+		fmt.Fprintf(out, "type %s struct {\n", real)
+		fmt.Fprintf(out, "\tmock %s\n", base)
+		fmt.Fprintf(out, "}\n\n")
+		fmt.Fprintf(out, "func (_m %s) %s() *%s {\n", base, m.ObjREAL, real)
+		fmt.Fprintf(out, "\treturn &%s{_m}\n", real)
+		fmt.Fprintf(out, "}\n\n")
 	}
 
 	return nil
 }
 
+// mockUnexported returns true if the unexported function (or method) fi has
+// been configured to be mocked.
+func (m *mockGen) mockUnexported(fi *funcInfo) bool {
+	if strings.HasPrefix(fi.name, "_") {
+		// can't be turned into an exported recorder name
+		return false
+	}
+	return m.unexported || m.unexportedFns[fi.scopedName()]
+}
+
+// setSignature fills in the params and results of fi from t.
+func (m *mockGen) setSignature(fi *funcInfo, t *ast.FuncType) {
+	for _, param := range t.Params.List {
+		p := field{
+			names: make([]string, len(param.Names)),
+			expr:  m.exprString(param.Type),
+		}
+		for i, name := range param.Names {
+			p.names[i] = name.String()
+		}
+		_, fi.varidic = param.Type.(*ast.Ellipsis)
+		fi.params = append(fi.params, p)
+	}
+	if t.Results != nil {
+		for _, result := range t.Results.List {
+			r := field{
+				names: make([]string, len(result.Names)),
+				expr:  m.exprString(result.Type),
+			}
+			for i, name := range result.Names {
+				r.names[i] = name.String()
+			}
+			fi.results = append(fi.results, r)
+		}
+	}
+}
+
+// funcVar returns the details of a package level variable that holds a
+// function, or nil if it doesn't (or we can't tell).  If the signature can't be
+// worked out from the source (e.g. "var Now = time.Now"), then the recorder just
+// takes ...interface{}, and the type is checked at runtime.  imports maps the
+// names of the packages imported by the file to their import paths.
+func (m *mockGen) funcVar(name string, typ, value ast.Expr, imports map[string]string) *funcInfo {
+	fi := &funcInfo{name: name, funcVar: true}
+	if t, ok := typ.(*ast.FuncType); ok {
+		m.setSignature(fi, t)
+		return fi
+	}
+	if typ != nil {
+		return nil
+	}
+	switch v := value.(type) {
+	case *ast.FuncLit:
+		m.setSignature(fi, v.Type)
+	case *ast.Ident, *ast.SelectorExpr:
+		if !m.isFunc(v, imports) {
+			return nil
+		}
+		fi.untyped = true
+		fi.varidic = true
+		fi.params = []field{{expr: "...interface{}"}}
+	default:
+		return nil
+	}
+	return fi
+}
+
+// isFunc returns true if value names a function, either one declared in this
+// package, or one declared in a package imported by the file.
+func (m *mockGen) isFunc(value ast.Expr, imports map[string]string) bool {
+	switch v := value.(type) {
+	case *ast.Ident:
+		return m.funcs[v.Name]
+	case *ast.SelectorExpr:
+		x, ok := v.X.(*ast.Ident)
+		if !ok {
+			return false
+		}
+		impPath, found := imports[x.Name]
+		if !found {
+			return false
+		}
+		funcs, err := getPackageFuncs(impPath)
+		if err != nil {
+			log.Printf("getPackageFuncs: %s", err)
+			return false
+		}
+		return funcs[v.Sel.Name]
+	}
+	return false
+}
+
 func (m *mockGen) extra(out io.Writer, tags, name string) error {
 	for _, line := range strings.Split(tags, "|") {
 		if len(line) == 0 {
@@ -1076,6 +2411,31 @@ func (m *mockGen) extra(out io.Writer, tags, name string) error {
 		fmt.Fprintf(out, "func (_m %s) %s() *%s {\n", base, m.ObjEXPECT, rec)
 		fmt.Fprintf(out, "\treturn &%s{_m}\n", rec)
 		fmt.Fprintf(out, "}\n\n")
+		real := realAccessor(rec)
+		fmt.Fprintf(out, "type %s struct {\n", real)
+		fmt.Fprintf(out, "\tmock %s\n", base)
+		fmt.Fprintf(out, "}\n\n")
+		fmt.Fprintf(out, "func (_m %s) %s() *%s {\n", base, m.ObjREAL, real)
+		fmt.Fprintf(out, "\treturn &%s{_m}\n", real)
+		fmt.Fprintf(out, "}\n\n")
+	}
+
+	primary := make(map[string]bool)
+	for _, urec := range m.unexportedRecs {
+		primary[urec] = true
+	}
+	accessors := m.unexportedAccessors()
+	urecs := m.taggedRec.u[tags]
+	for base, urec := range urecs {
+		if primary[urec] {
+			// already in the primary mock file
+			continue
+		}
+		if _, found := urecs[base[1:]]; base[0] == '*' && found {
+			// If pointer and non-pointer receiver, just use the non-pointer
+			continue
+		}
+		writeUnexportedRecorder(out, base, urec, accessors[urec])
 	}
 
 	return nil
@@ -1083,6 +2443,47 @@ func (m *mockGen) extra(out io.Writer, tags, name string) error {
 
 var pkgNames = map[string]string{}
 
+var pkgFuncs = map[string]map[string]bool{}
+
+// getPackageFuncs returns the names of the package level functions declared in
+// the package with the given import path.
+func getPackageFuncs(impPath string) (map[string]bool, error) {
+	if funcs, found := pkgFuncs[impPath]; found {
+		return funcs, nil
+	}
+
+	path, err := LookupImportPath(impPath)
+	if err != nil {
+		return nil, Cerr{"LookupImportPath", err}
+	}
+
+	isGoFile := func(info os.FileInfo) bool {
+		if info.IsDir() {
+			return false
+		}
+		if strings.HasSuffix(info.Name(), "_test.go") {
+			return false
+		}
+		return strings.HasSuffix(info.Name(), ".go")
+	}
+
+	fset := token.NewFileSet()
+	pkgs, err := parser.ParseDir(fset, path, isGoFile, 0)
+	if err != nil {
+		return nil, Cerr{"parser.ParseDir", err}
+	}
+
+	funcs := make(map[string]bool)
+	for _, pkg := range pkgs {
+		for _, file := range pkg.Files {
+			addFuncs(funcs, file)
+		}
+	}
+
+	pkgFuncs[impPath] = funcs
+	return funcs, nil
+}
+
 func getVendorPaths(pkgName string) []string {
 	vendors := []string{}
 	for len(pkgName) > 0 {
@@ -1220,6 +2621,7 @@ func (m *mockGen) file(out io.Writer, f *ast.File, filename string) (map[string]
 
 	imports := make(map[string]string)
 	inits := []string{}
+	funcVars := []*funcInfo{}
 
 	fmt.Fprintf(out, "package %s\n\n", f.Name)
 
@@ -1298,16 +2700,16 @@ func (m *mockGen) file(out io.Writer, f *ast.File, filename string) (map[string]
 				// We can't ignore private types, as we might be using them.
 				if len(d.Specs) == 1 {
 					t := d.Specs[0].(*ast.TypeSpec)
-					fmt.Fprintf(out, "type %s %s\n\n", t.Name, m.exprString(t.Type))
+					fmt.Fprintf(out, "type %s\n\n", m.typeSpecString(t))
 					m.types[t.Name.String()] = t.Type
-					m.ifInfo.addType(t, imports)
+					m.ifInfo.addType(m.fset, t, imports)
 				} else {
 					fmt.Fprintf(out, "type (\n")
 					for i := range d.Specs {
 						t := d.Specs[i].(*ast.TypeSpec)
-						fmt.Fprintf(out, "\t%s %s\n", t.Name, m.exprString(t.Type))
+						fmt.Fprintf(out, "\t%s\n", m.typeSpecString(t))
 						m.types[t.Name.String()] = t.Type
-						m.ifInfo.addType(t, imports)
+						m.ifInfo.addType(m.fset, t, imports)
 					}
 					fmt.Fprintf(out, ")\n\n")
 				}
@@ -1323,6 +2725,18 @@ func (m *mockGen) file(out io.Writer, f *ast.File, filename string) (map[string]
 					if s.Type != nil {
 						fmt.Fprintf(out, " %s", m.exprString(s.Type))
 					}
+					for i, ident := range s.Names {
+						if !ident.IsExported() {
+							continue
+						}
+						var value ast.Expr
+						if len(s.Values) == len(s.Names) {
+							value = s.Values[i]
+						}
+						if fi := m.funcVar(ident.Name, s.Type, value, imports); fi != nil {
+							funcVars = append(funcVars, fi)
+						}
+					}
 					switch len(s.Values) {
 					case 0:
 					case 1:
@@ -1377,10 +2791,11 @@ func (m *mockGen) file(out io.Writer, f *ast.File, filename string) (map[string]
 				if len(d.Recv.List[0].Names) > 0 {
 					fi.recv.name = d.Recv.List[0].Names[0].String()
 				}
-				t := m.exprString(d.Recv.List[0].Type)
+				recvType := m.resolveAlias(d.Recv.List[0].Type)
+				t := m.exprString(recvType)
 				fi.recv.expr = t
 				recorder = fmt.Sprintf("_%s_Rec", t)
-				if s, ok := d.Recv.List[0].Type.(*ast.StarExpr); ok {
+				if s, ok := recvType.(*ast.StarExpr); ok {
 					recorder = fmt.Sprintf("_%s_Rec", m.exprString(s.X))
 				}
 				if len(buildTags) == 0 {
@@ -1389,29 +2804,7 @@ func (m *mockGen) file(out io.Writer, f *ast.File, filename string) (map[string]
 					m.taggedRec.Add(buildTags, t, recorder)
 				}
 			}
-			for _, param := range d.Type.Params.List {
-				p := field{
-					names: make([]string, len(param.Names)),
-					expr:  m.exprString(param.Type),
-				}
-				for i, name := range param.Names {
-					p.names[i] = name.String()
-				}
-				_, fi.varidic = param.Type.(*ast.Ellipsis)
-				fi.params = append(fi.params, p)
-			}
-			if d.Type.Results != nil {
-				for _, result := range d.Type.Results.List {
-					r := field{
-						names: make([]string, len(result.Names)),
-						expr:  m.exprString(result.Type),
-					}
-					for i, name := range result.Names {
-						r.names[i] = name.String()
-					}
-					fi.results = append(fi.results, r)
-				}
-			}
+			m.setSignature(fi, d.Type)
 			if d.Body != nil {
 				pos1 := m.fset.Position(d.Body.Lbrace)
 				pos2 := m.fset.Position(d.Body.Rbrace)
@@ -1422,7 +2815,12 @@ func (m *mockGen) file(out io.Writer, f *ast.File, filename string) (map[string]
 				}
 			}
 
-			if fi.name == "init" && !fi.IsMethod() {
+			isInit := fi.name == "init" && !fi.IsMethod()
+			if !d.Name.IsExported() && !isInit && d.Body != nil {
+				fi.unexported = m.mockUnexported(fi)
+			}
+
+			if isInit {
 				fi.name = fmt.Sprintf("_real_init_%d", m.initCount)
 				fi.writeReal(out)
 				if m.callInits {
@@ -1439,7 +2837,27 @@ func (m *mockGen) file(out io.Writer, f *ast.File, filename string) (map[string]
 					m.extFunctions = append(m.extFunctions, d.Name.Name)
 				}
 				fi.writeMock(out)
-				fi.writeRecorder(out, recorder)
+				if m.typedRecorders {
+					fi.writeTypedRecorder(out, recorder)
+				} else {
+					fi.writeRecorder(out, recorder)
+				}
+				fi.writeRealAccessor(out, realAccessor(recorder))
+			} else if fi.unexported {
+				urec := unexportedRecorder(recorder)
+				if !fi.IsMethod() {
+					m.unexportedNames[exportedName(fi.name)] = true
+				} else if len(buildTags) == 0 {
+					m.unexportedRecs[fi.recv.expr] = urec
+				} else {
+					m.taggedRec.AddUnexported(buildTags, fi.recv.expr, urec)
+				}
+				fi.writeMock(out)
+				if m.typedRecorders {
+					fi.writeTypedRecorder(out, urec)
+				} else {
+					fi.writeRecorder(out, urec)
+				}
 			}
 			fmt.Fprintf(out, "\n")
 		default:
@@ -1455,6 +2873,23 @@ func (m *mockGen) file(out io.Writer, f *ast.File, filename string) (map[string]
 	fmt.Fprintf(out, "\tcallInits(%s)\n", strings.Join(inits, ", "))
 	fmt.Fprintf(out, "}\n")
 
+	if len(funcVars) > 0 {
+		fmt.Fprintf(out, "\n")
+		for _, fi := range funcVars {
+			if m.typedRecorders && !fi.untyped {
+				fi.writeTypedRecorder(out, "_package_Rec")
+			} else {
+				fi.writeRecorder(out, "_package_Rec")
+			}
+		}
+		fmt.Fprintf(out, "\n// Replace function variables with mocks\n")
+		fmt.Fprintf(out, "func init() {\n")
+		for _, fi := range funcVars {
+			fmt.Fprintf(out, "\t_mockFuncVar(\"%s\", &%s)\n", fi.name, fi.name)
+		}
+		fmt.Fprintf(out, "}\n")
+	}
+
 	i := map[string]bool{
 		"github.com/golang/mock/gomock": false,
 	}
@@ -1512,7 +2947,7 @@ func loadInterfaceInfo(impPath string) (*ifInfo, error) {
 					if d.Tok == token.TYPE {
 						for i := range d.Specs {
 							t := d.Specs[i].(*ast.TypeSpec)
-							ifInfo.addType(t, imports)
+							ifInfo.addType(fset, t, imports)
 						}
 					}
 				}
@@ -21,8 +21,11 @@ type Package interface {
 
 	DisableInstall()
 
-	GetImports() (importSet, error)
-	MockImports(map[string]string, *Config) error
+	Directives() (*directives, error)
+	GetImports(*directives) (importSet, error)
+	MockImports(map[string]string, *directives, *Config) error
+	MockSelf(map[string]string, *directives, *Config) error
+	RewriteImports(map[string]string, *Config) error
 
 	Link() (importSet, error)
 	Gen(mock bool, cfg *MockConfig) (importSet, error)
@@ -90,12 +93,25 @@ func (p *realPackage) DisableInstall() {
 	p.install = false
 }
 
-func (p *realPackage) GetImports() (importSet, error) {
-	return GetImports(p.path, true)
+func (p *realPackage) Directives() (*directives, error) {
+	return readDirectives(p.path)
 }
 
-func (p *realPackage) MockImports(importNames map[string]string, cfg *Config) error {
-	return MockImports(p.src, p.dst, importNames, cfg)
+func (p *realPackage) GetImports(d *directives) (importSet, error) {
+	return GetImports(p.path, d)
+}
+
+func (p *realPackage) MockImports(importNames map[string]string, d *directives, cfg *Config) error {
+	return MockImports(p.src, p.dst, importNames, d, cfg)
+}
+
+func (p *realPackage) MockSelf(importNames map[string]string, d *directives, cfg *Config) error {
+	stage := filepath.Join(p.tmpDir, "self", p.label)
+	return MockSelf(p.src, p.dst, stage, p.name, importNames, d, cfg)
+}
+
+func (p *realPackage) RewriteImports(importNames map[string]string, cfg *Config) error {
+	return RewriteImports(p.dst, importNames, cfg)
 }
 
 func (p *realPackage) Link() (importSet, error) {
@@ -168,8 +184,8 @@ func (p *realPackage) Install() error {
 	cmd := p.insideCommand("go", "install", p.label)
 	out, err := cmd.CombinedOutput()
 	if err != nil {
-		return fmt.Errorf("Failed to install '%s': %s\noutput:\n%s",
-			p.label, err, out)
+		return fmt.Errorf("Failed to install %s version of '%s': %s\n"+
+			"output:\n%s", getMark(p.label), p.name, err, unlabel(string(out)))
 	}
 	return nil
 }
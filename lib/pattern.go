@@ -0,0 +1,130 @@
+// Copyright 2013 Julian Phillips.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package lib
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Package patterns can be used in place of an import path in the config file,
+// and in the files given to -exclude and -P.  In a pattern "..." matches any
+// string (including "/"), "*" matches any string not containing a "/" and "?"
+// matches any single character other than "/".  As with the go tool, a trailing
+// "/..." also matches the path without it, so "example.com/sdk/..." matches
+// "example.com/sdk" as well as "example.com/sdk/client".
+
+var patterns = map[string]*regexp.Regexp{}
+
+func isPattern(s string) bool {
+	return strings.Contains(s, "...") || strings.ContainsAny(s, "*?")
+}
+
+func compilePattern(pat string) *regexp.Regexp {
+	re, found := patterns[pat]
+	if found {
+		return re
+	}
+
+	expr := ""
+	rest := pat
+	if strings.HasSuffix(rest, "/...") {
+		rest = rest[:len(rest)-4]
+	}
+	for len(rest) > 0 {
+		switch {
+		case strings.HasPrefix(rest, "..."):
+			expr += ".*"
+			rest = rest[3:]
+		case rest[0] == '*':
+			expr += "[^/]*"
+			rest = rest[1:]
+		case rest[0] == '?':
+			expr += "[^/]"
+			rest = rest[1:]
+		default:
+			expr += regexp.QuoteMeta(rest[:1])
+			rest = rest[1:]
+		}
+	}
+	if strings.HasSuffix(pat, "/...") {
+		expr += "(/.*)?"
+	}
+
+	re = regexp.MustCompile("^" + expr + "$")
+	patterns[pat] = re
+	return re
+}
+
+// matchPattern returns true if path matches pat, which may be a literal import
+// path.
+func matchPattern(pat, path string) bool {
+	if !isPattern(pat) {
+		return pat == path
+	}
+	return compilePattern(pat).MatchString(path)
+}
+
+// literals returns the number of characters in pat that aren't wildcards, which
+// is used to decide which pattern is the most specific.
+func literals(pat string) int {
+	n := len(pat)
+	n -= 3 * strings.Count(pat, "...")
+	n -= strings.Count(pat, "*") + strings.Count(pat, "?")
+	return n
+}
+
+// bestMatch returns the entry of pats that matches path.  An exact match is
+// always used if there is one, otherwise the most specific pattern (the one
+// with the most non-wildcard characters) is used, with ties going to the
+// pattern that sorts first.
+func bestMatch(path string, pats []string) (string, bool) {
+	matches := []string{}
+	for _, pat := range pats {
+		if pat == path {
+			return pat, true
+		}
+		if isPattern(pat) && matchPattern(pat, path) {
+			matches = append(matches, pat)
+		}
+	}
+
+	if len(matches) == 0 {
+		return "", false
+	}
+
+	sort.Slice(matches, func(i, j int) bool {
+		li, lj := literals(matches[i]), literals(matches[j])
+		if li != lj {
+			return li > lj
+		}
+		return matches[i] < matches[j]
+	})
+
+	return matches[0], true
+}
+
+// expandPattern returns the packages that match pat, which must be a pattern.
+// The go tool is used to find the packages, though it only understands "...",
+// so the results are filtered using our own matching.
+func expandPattern(pat string) ([]string, error) {
+	wide := strings.NewReplacer("*", "...", "?", "...").Replace(pat)
+
+	list, err := GetOutput("go", "list", wide)
+	if err != nil {
+		return nil, Cerr{"go list", err}
+	}
+
+	pkgs := []string{}
+	for _, pkg := range strings.Split(list, "\n") {
+		pkg = strings.TrimSpace(pkg)
+		if pkg != "" && matchPattern(pat, pkg) {
+			pkgs = append(pkgs, pkg)
+		}
+	}
+
+	return pkgs, nil
+}
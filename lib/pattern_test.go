@@ -0,0 +1,59 @@
+// Copyright 2013 Julian Phillips.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package lib
+
+import (
+	"testing"
+)
+
+func TestMatchPattern(t *testing.T) {
+	tests := []struct {
+		pat, path string
+		match     bool
+	}{
+		{"example.com/sdk", "example.com/sdk", true},
+		{"example.com/sdk", "example.com/sdk/client", false},
+		{"example.com/sdk/...", "example.com/sdk", true},
+		{"example.com/sdk/...", "example.com/sdk/client/v2", true},
+		{"example.com/sdk/...", "example.com/sdkx", false},
+		{"*/internal/*", "example.com/internal/util", true},
+		{"*/internal/*", "example.com/sdk/internal/util", false},
+		{".../internal/...", "example.com/sdk/internal/util/v2", true},
+		{"example.com/sdk/v?", "example.com/sdk/v2", true},
+		{"example.com/sdk", "exampleXcom/sdk", false},
+	}
+
+	for _, test := range tests {
+		if match := matchPattern(test.pat, test.path); match != test.match {
+			t.Errorf("matchPattern(%q, %q): expected %v, got %v", test.pat,
+				test.path, test.match, match)
+		}
+	}
+}
+
+func TestBestMatch(t *testing.T) {
+	pats := []string{
+		"example.com/...",
+		"example.com/sdk/...",
+		"example.com/sdk/client",
+	}
+
+	tests := []struct {
+		path, match string
+	}{
+		{"example.com/sdk/client", "example.com/sdk/client"},
+		{"example.com/sdk/server", "example.com/sdk/..."},
+		{"example.com/other", "example.com/..."},
+		{"other.com/sdk", ""},
+	}
+
+	for _, test := range tests {
+		match, _ := bestMatch(test.path, pats)
+		if match != test.match {
+			t.Errorf("bestMatch(%q): expected %q, got %q", test.path,
+				test.match, match)
+		}
+	}
+}
@@ -14,6 +14,7 @@ type rewriter struct {
 	w        io.Writer
 	buf      *bytes.Buffer
 	rewrites []rw
+	labels   bool
 }
 
 type rw struct {
@@ -31,14 +32,26 @@ func (r *rewriter) Rewrite(src, dst string) {
 	r.rewrites = append(r.rewrites, rw{[]byte(src), []byte(dst)})
 }
 
+// RewriteLabels causes any import labels (see markImport) to be replaced with
+// the original import path.
+func (r *rewriter) RewriteLabels() {
+	r.labels = true
+}
+
+func (r *rewriter) rewrite(line []byte) []byte {
+	for _, rw := range r.rewrites {
+		line = bytes.Replace(line, rw.match, rw.replace, -1)
+	}
+	if r.labels {
+		line = []byte(unlabel(string(line)))
+	}
+	return line
+}
+
 func (r *rewriter) flushLines() error {
 	line, err := r.buf.ReadBytes('\n')
 	for err == nil {
-		for _, rw := range r.rewrites {
-			line = bytes.Replace(line, rw.match, rw.replace, -1)
-		}
-
-		_, err = r.w.Write(line)
+		_, err = r.w.Write(r.rewrite(line))
 		if err != nil {
 			return err
 		}
@@ -64,12 +77,7 @@ func (r *rewriter) flush() error {
 		return nil
 	}
 
-	line := r.buf.Bytes()
-	for _, rw := range r.rewrites {
-		line = bytes.Replace(line, rw.match, rw.replace, -1)
-	}
-
-	_, err := r.w.Write(line)
+	_, err := r.w.Write(r.rewrite(r.buf.Bytes()))
 	if err != nil {
 		return err
 	}
@@ -0,0 +1,46 @@
+// Copyright 2013 Julian Phillips.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package lib
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestRewriterLabels(t *testing.T) {
+	out := &bytes.Buffer{}
+
+	rw := NewRewriter(out)
+	rw.Rewrite("/tmp/withmock123/path/src", "/home/user/go/src")
+	rw.RewriteLabels()
+
+	// Write the output in pieces that split both the lines and the labels, as
+	// output from a command can arrive in any size chunks.
+	input := "--- FAIL: TestThing\n" +
+		"\t/tmp/withmock123/path/src/withmock.invalid/test/example.com/app/" +
+		"app_test.go:12: got withmock.invalid/mock/net/http.Header\n" +
+		"FAIL\twithmock.invalid/test/example.com/app"
+	for len(input) > 0 {
+		n := 7
+		if n > len(input) {
+			n = len(input)
+		}
+		if _, err := rw.Write([]byte(input[:n])); err != nil {
+			t.Fatalf("Write: unexpected error: %s", err)
+		}
+		input = input[n:]
+	}
+	if err := rw.Close(); err != nil {
+		t.Fatalf("Close: unexpected error: %s", err)
+	}
+
+	expected := "--- FAIL: TestThing\n" +
+		"\t/home/user/go/src/example.com/app/app_test.go:12: got " +
+		"net/http.Header\n" +
+		"FAIL\texample.com/app"
+	if out.String() != expected {
+		t.Errorf("expected:\n%s\ngot:\n%s", expected, out.String())
+	}
+}
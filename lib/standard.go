@@ -5,21 +5,24 @@
 package lib
 
 import (
+	"bytes"
 	"fmt"
-	"go/ast"
 	"go/parser"
 	"go/token"
 	"io"
+	"io/ioutil"
 	"os"
+	"strconv"
 	"strings"
 )
 
+// rewrite replaces the bytes of a file between start and end with content.
 type rewrite struct {
-	offset  int
-	content string
+	start, end int
+	content    string
 }
 
-func mockFileImports(src, dst string, change map[string]string, cfg *Config) error {
+func mockFileImports(src, dst string, change map[string]string, d *directives, cfg *Config) error {
 	fset := token.NewFileSet()
 	file, err := parser.ParseFile(fset, src, nil,
 		parser.ImportsOnly|parser.ParseComments)
@@ -30,49 +33,45 @@ func mockFileImports(src, dst string, change map[string]string, cfg *Config) err
 	testFile := strings.HasSuffix(src, "_test.go")
 	rewrites := []rewrite{}
 
-	for _, decl := range file.Decls {
-		g, ok := decl.(*ast.GenDecl)
-		if !ok {
-			continue
+	for _, s := range file.Imports {
+		impPath, marked := importPath(s)
+		newPath := change[impPath]
+
+		if newPath == "" && marked {
+			// The package is mocked in place, but we still need to remove
+			// the _mock_/ prefix
+			newPath = impPath
 		}
 
-		if g.Tok != token.IMPORT {
+		if newPath == "" {
+			// no change needed
 			continue
 		}
 
-		for _, spec := range g.Specs {
-			s := spec.(*ast.ImportSpec)
-
-			impPath := strings.Trim(s.Path.Value, "\"")
-			newPath := change[impPath]
-
-			if newPath == "" {
-				// no change needed
+		if testFile && !marked && getMark(newPath) != testMark {
+			// for test files, we only replace the import if it was marked
+			// to be mocked (as the test code might want the non-mocked
+			// version too), unless the mark is testMark - which means we
+			// are importing the code under test, and we want to make sure
+			// we get the actual code under test, not an unmodified copy.
+			comment := d.comment(impPath, strings.TrimSpace(s.Comment.Text()))
+			_, _, isMock := parseMockComment(comment)
+			if !isMock && !cfg.isMocked(impPath) {
 				continue
 			}
-
-			if testFile && getMark(newPath) != testMark {
-				// for test files, we only replace the import if it was marked
-				// to be mocked (as the test code might want the non-mocked
-				// version too), unless the mark is testMark - which means we
-				// are importing the code under test, and we want to make sure
-				// we get the actual code under test, not an unmodified copy.
-				comment := strings.TrimSpace(s.Comment.Text())
-				if strings.ToLower(comment) != "mock" {
-					continue
-				}
-			}
-
-			start := fset.Position(s.Path.Pos()).Offset
-			rewrites = append(rewrites, rewrite{start + 1, change[impPath]})
 		}
+
+		// Replace just the path, so that the name (including "." and "_"),
+		// comments and positions of everything else are left alone.
+		start := fset.Position(s.Path.Pos()).Offset
+		end := fset.Position(s.Path.End()).Offset
+		rewrites = append(rewrites, rewrite{start, end, strconv.Quote(newPath)})
 	}
 
-	r, err := os.Open(src)
+	data, err := ioutil.ReadFile(src)
 	if err != nil {
 		return err
 	}
-	defer r.Close()
 
 	w, err := os.Create(dst)
 	if err != nil {
@@ -80,40 +79,86 @@ func mockFileImports(src, dst string, change map[string]string, cfg *Config) err
 	}
 	defer w.Close()
 
-	// Start by copying the complete file contents
-	_, err = io.Copy(w, r)
-	if err != nil {
+	// Copy the file contents, applying the rewrites as we go.  The labels
+	// aren't the same length as the paths they replace, so the rewrites are
+	// spliced in, rather than written over the original.
+	pos := 0
+	for _, rw := range rewrites {
+		if _, err := w.Write(data[pos:rw.start]); err != nil {
+			return err
+		}
+		if _, err := io.WriteString(w, rw.content); err != nil {
+			return err
+		}
+		pos = rw.end
+	}
+	if _, err := w.Write(data[pos:]); err != nil {
 		return err
 	}
 
 	// Add an init function to setup any mocks, if this is a test file that
 	// needs mocks enabled
-	if strings.HasSuffix(src, "_test.go") {
-		i, err := GetMockedPackages(src)
+	if testFile {
+		i, err := GetMockedPackages(src, d, cfg)
 		if err != nil {
 			return err
 		}
-		if len(i) > 0 {
-			fmt.Fprintf(w, "\nfunc init() {\n")
-			for pkg, impPath := range i {
-				c := cfg.Mock(impPath)
-				fmt.Fprintf(w, "\t%s.%s().MockAll(true)\n", pkg, c.MOCK)
-			}
-			fmt.Fprintf(w, "}\n")
+		if err := writeMockInit(w, i, cfg); err != nil {
+			return err
 		}
 	}
 
-	// Now we go back and apply any rewrites
-	for _, rw := range rewrites {
-		_, err := w.Seek(int64(rw.offset), 0)
-		if err != nil {
-			return err
+	return nil
+}
+
+// writeMockInit writes an init function to w that enables the mocks for the
+// mocked imports in i (as returned by GetMockedPackages).  Nothing is written
+// if there is nothing to enable.
+func writeMockInit(w io.Writer, i []*MockedImport, cfg *Config) error {
+	mocked := make([]*MockedImport, 0, len(i))
+	for _, mi := range i {
+		// A blank import can't be referred to, so there is nothing we can do
+		// to enable the mocks.
+		if mi.Name != "_" {
+			mocked = append(mocked, mi)
 		}
-		_, err = w.WriteString(rw.content)
-		if err != nil {
-			return err
+	}
+
+	if len(mocked) == 0 {
+		return nil
+	}
+
+	buf := &bytes.Buffer{}
+
+	fmt.Fprintf(buf, "\nfunc init() {\n")
+	for _, mi := range mocked {
+		c := cfg.Mock(mi.Path)
+
+		// The MOCK function of a dot import is used without a qualifier
+		meta := c.MOCK + "()"
+		if mi.Name != "." {
+			meta = mi.Name + "." + meta
 		}
+
+		if len(mi.Names) == 0 || mi.Except {
+			fmt.Fprintf(buf, "\t%s.MockAll(true)\n", meta)
+		}
+		if len(mi.Names) == 0 {
+			continue
+		}
+		names := make([]string, len(mi.Names))
+		for n, name := range mi.Names {
+			names[n] = strconv.Quote(name)
+		}
+		method := "EnableMock"
+		if mi.Except {
+			method = "DisableMock"
+		}
+		fmt.Fprintf(buf, "\t%s.%s(%s)\n", meta, method,
+			strings.Join(names, ", "))
 	}
+	fmt.Fprintf(buf, "}\n")
 
-	return nil
+	_, err := buf.WriteTo(w)
+	return err
 }
@@ -23,6 +23,8 @@ var (
 	exclFile = flag.String("exclude", "", "any package listed in the given file will not be mocked, even if marked in test code.")
 	cfgFile  = flag.String("c", "", "load config from the specified file")
 	debug    = flag.Bool("debug", false, "enable extra output for debugging mock genertion issues")
+	fixtures = flag.String("fixtures", "", "record calls to mocked packages into fixture files, replay them, or learn EXPECT statements from them (record, replay or learn)")
+	fixDir   = flag.String("fixture-dir", "testdata/fixtures", "directory to store fixture files in")
 )
 
 func usage() {
@@ -32,6 +34,8 @@ func usage() {
 		"where imports of the package in the current directory which are "+
 		"marked for mocking are replacement by automatically generated mock "+
 		"versions for use with gomock.\n\n")
+	fmt.Fprintf(os.Stderr, "Use '%s config [package]' to show the config "+
+		"that would be used for a package.\n\n", os.Args[0])
 	fmt.Fprintf(os.Stderr, "options:\n\n")
 	flag.PrintDefaults()
 }
@@ -95,6 +99,14 @@ func doit() error {
 		ctxt.DisableRewrite()
 	}
 
+	// Setup recording or replay of fixtures if requested
+
+	if *fixtures != "" {
+		if err := ctxt.SetFixtures(*fixtures, *fixDir); err != nil {
+			return err
+		}
+	}
+
 	// Load the excluded packages file if configured
 
 	if *exclFile != "" {
@@ -103,7 +115,21 @@ func doit() error {
 		}
 	}
 
-	// Load the config file if specified
+	// Load any config files found for the package being tested (or shown by
+	// the config command), and then the config file given on the command line
+	// (if specified)
+
+	cfgDir := "."
+	if flag.Arg(0) == "config" && flag.Arg(1) != "" {
+		cfgDir, err = lib.LookupImportPath(flag.Arg(1))
+		if err != nil {
+			return err
+		}
+	}
+
+	if err := ctxt.LoadConfigs(cfgDir); err != nil {
+		return err
+	}
 
 	if *cfgFile != "" {
 		if err := ctxt.LoadConfig(*cfgFile); err != nil {
@@ -111,6 +137,12 @@ func doit() error {
 		}
 	}
 
+	// "withmock config [package]" just shows the config that would be used
+
+	if flag.Arg(0) == "config" {
+		return ctxt.WriteConfig(os.Stdout)
+	}
+
 	// Now we add the package that we want to test to the context, this will
 	// install the imports used by that package (mocking them as approprite).
 
@@ -132,6 +164,15 @@ func doit() error {
 		}
 	}
 
+	if err := ctxt.LinkPackagesFromConfig(); err != nil {
+		return err
+	}
+
+	// Warn about any packages in the config that were never imported, as the
+	// path is probably wrong.
+
+	ctxt.WarnUnusedConfig()
+
 	// Add in the gocov library, so that we can run with gocov if we want.
 
 	if flag.Arg(0) == "gocov" || *gocov {
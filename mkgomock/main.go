@@ -35,10 +35,7 @@ func main() {
 
 	srcPath, dstPath, impPath := args[1], args[2], args[3]
 
-	cfg := &lib.MockConfig{
-		MOCK:   "MOCK",
-		EXPECT: "EXPECT",
-	}
+	cfg := (&lib.Config{}).Mock(impPath)
 
 	_, err := lib.MakePkg(srcPath, dstPath, impPath, true, cfg)
 	if err != nil {
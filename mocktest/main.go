@@ -26,6 +26,8 @@ var (
 	exclFile = flag.String("exclude", "", "any package listed in the given file will not be mocked, even if marked in test code.")
 	cfgFile  = flag.String("c", "", "load config from the specified file")
 	debug    = flag.Bool("debug", false, "enable extra output for debugging mock genertion issues")
+	fixtures = flag.String("fixtures", "", "record calls to mocked packages into fixture files, replay them, or learn EXPECT statements from them (record, replay or learn)")
+	fixDir   = flag.String("fixture-dir", "testdata/fixtures", "directory to store fixture files in")
 )
 
 func usage() {
@@ -119,6 +121,14 @@ func doit() error {
 		ctxt.DisableRewrite()
 	}
 
+	// Setup recording or replay of fixtures if requested
+
+	if *fixtures != "" {
+		if err := ctxt.SetFixtures(*fixtures, *fixDir); err != nil {
+			return lib.Cerr{"SetFixtures", err}
+		}
+	}
+
 	// Load the excluded packages file if configured
 
 	if *exclFile != "" {
@@ -127,7 +137,19 @@ func doit() error {
 		}
 	}
 
-	// Load the config file if specified
+	// Load any config files found for each of the packages being tested, and
+	// then the config file given on the command line (if specified)
+
+	for _, pkg := range pkgs {
+		cfgDir, err := lib.LookupImportPath(pkg)
+		if err != nil {
+			return lib.Cerr{"LookupImportPath", err}
+		}
+
+		if err := ctxt.LoadConfigs(cfgDir); err != nil {
+			return lib.Cerr{"LoadConfigs", err}
+		}
+	}
 
 	if *cfgFile != "" {
 		if err := ctxt.LoadConfig(*cfgFile); err != nil {
@@ -165,6 +187,15 @@ func doit() error {
 		}
 	}
 
+	if err := ctxt.LinkPackagesFromConfig(); err != nil {
+		return lib.Cerr{"LinkPackagesFromConfig", err}
+	}
+
+	// Warn about any packages in the config that were never imported, as the
+	// path is probably wrong.
+
+	ctxt.WarnUnusedConfig()
+
 	// Add in the gocov library, so that we can run with gocov if we want.
 
 	if *gocov {
@@ -0,0 +1,14 @@
+package code
+
+import (
+	"github.com/qur/withmock/scenarios/alias/lib"
+)
+
+func Describe(c *lib.Client, p lib.Named[int]) string {
+	return c.Name() + "," + p.Key
+}
+
+func Size(r lib.Reader) int {
+	n, _ := r.Read(make([]byte, 10))
+	return n
+}
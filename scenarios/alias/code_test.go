@@ -0,0 +1,39 @@
+package code
+
+import (
+	"testing"
+
+	"github.com/golang/mock/gomock"
+
+	"github.com/qur/withmock/scenarios/alias/lib" // mock
+)
+
+func TestDescribe(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	lib.MOCK().SetController(ctrl)
+
+	c := &lib.Client{}
+	c.EXPECT().Name().Return("client")
+
+	p := lib.Named[int]{Key: "key", Val: 1}
+
+	if s := Describe(c, p); s != "client,key" {
+		t.Errorf("Expected 'client,key', got '%s'", s)
+	}
+}
+
+func TestSize(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	lib.MOCK().SetController(ctrl)
+
+	r := lib.MOCK().NewReader()
+	r.EXPECT().Read(gomock.Any()).Return(5, nil)
+
+	if n := Size(r); n != 5 {
+		t.Errorf("Expected 5, got %d", n)
+	}
+}
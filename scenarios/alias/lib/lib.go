@@ -0,0 +1,28 @@
+package lib
+
+import (
+	"io"
+)
+
+type Client = client
+
+type Reader = io.Reader
+
+type Pair[K comparable, V any] struct {
+	Key K
+	Val V
+}
+
+type Named[V any] = Pair[string, V]
+
+type client struct {
+	name string
+}
+
+func NewClient(name string) *Client {
+	return &Client{name}
+}
+
+func (c *Client) Name() string {
+	return c.name
+}
@@ -0,0 +1,9 @@
+package code
+
+import (
+	"github.com/qur/withmock/scenarios/concurrent/lib"
+)
+
+func TryMe(c *lib.Counter, x int) int {
+	return c.Add(lib.Value(x))
+}
@@ -0,0 +1,67 @@
+package code
+
+import (
+	"runtime"
+	"sync"
+	"testing"
+
+	"github.com/golang/mock/gomock"
+
+	"github.com/qur/withmock/scenarios/concurrent/lib" // mock
+)
+
+func TestConcurrent(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	lib.MOCK().SetController(ctrl)
+
+	// Calls without expectations go to the real code, however the settings
+	// below are changed
+	lib.MOCK().LooseAll(true)
+	lib.MOCK().LooseReal(true)
+
+	c := &lib.Counter{}
+
+	wg := sync.WaitGroup{}
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 500; j++ {
+				if ret := TryMe(c, 1); ret != 1 {
+					t.Errorf("TryMe returned %d, not 1", ret)
+					return
+				}
+				runtime.Gosched()
+			}
+		}()
+	}
+
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	// Change the settings while the calls are being made
+	for i := 0; ; i++ {
+		select {
+		case <-done:
+			return
+		default:
+		}
+		lib.MOCK().MockAll(i%2 == 0)
+		lib.MOCK().EnableMock("Value")
+		lib.MOCK().DisableMock("Counter.Add")
+		lib.MOCK().EnableMockFor(c, "Counter.Add")
+		lib.MOCK().DisableMockFor(c)
+		lib.MOCK().SpyAll(i%3 == 0)
+		lib.MOCK().EnableSpy("Value")
+		lib.MOCK().DisableSpy("Counter.Add")
+		lib.MOCK().LooseAll(true)
+		lib.MOCK().EnableLoose("Value")
+		lib.MOCK().ResetCalls()
+		runtime.Gosched()
+	}
+}
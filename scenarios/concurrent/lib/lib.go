@@ -0,0 +1,13 @@
+package lib
+
+type Counter struct {
+	n int
+}
+
+func (c *Counter) Add(n int) int {
+	return c.n + n
+}
+
+func Value(x int) int {
+	return x
+}
@@ -0,0 +1,31 @@
+package code
+
+import (
+	"testing"
+
+	"github.com/golang/mock/gomock"
+
+	// This is mocked by the .withmock.yaml in this directory, which is found
+	// without needing to be given using -c
+	"github.com/qur/withmock/scenarios/config_discovery/lib"
+)
+
+func TestTryMe(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	lib.MOCK().SetController(ctrl)
+
+	lib.ON().Fetch("key").Return("value", nil)
+
+	// Run the function we want to test
+	value, err := TryMe()
+
+	if err != nil {
+		t.Errorf("Unexpected error return: %s", err)
+	}
+
+	if value != "value" {
+		t.Errorf("Expected 'value', got '%s'", value)
+	}
+}
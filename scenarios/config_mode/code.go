@@ -0,0 +1,10 @@
+package code
+
+import (
+	"github.com/qur/withmock/scenarios/config_mode/lib"
+	"github.com/qur/withmock/scenarios/config_mode/lib2"
+)
+
+func TryMe() (string, error) {
+	return lib.Fetch(lib2.Key)
+}
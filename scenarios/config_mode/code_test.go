@@ -0,0 +1,32 @@
+package code
+
+import (
+	"testing"
+
+	"github.com/golang/mock/gomock"
+
+	// Both of these are handled by the mode set in mock.yml, rather than
+	// by a comment
+	"github.com/qur/withmock/scenarios/config_mode/lib"
+	_ "github.com/qur/withmock/scenarios/config_mode/lib2"
+)
+
+func TestTryMe(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	lib.MOCK().SetController(ctrl)
+
+	lib.EXPECT().Fetch("fake").Return("value", nil)
+
+	// Run the function we want to test
+	value, err := TryMe()
+
+	if err != nil {
+		t.Errorf("Unexpected error return: %s", err)
+	}
+
+	if value != "value" {
+		t.Errorf("Expected 'value', got '%s'", value)
+	}
+}
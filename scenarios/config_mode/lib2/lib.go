@@ -0,0 +1,7 @@
+package lib2
+
+var Key = "real"
+
+func init() {
+	panic("can't use me")
+}
@@ -0,0 +1,9 @@
+package code
+
+import (
+	"github.com/qur/withmock/scenarios/config_strict/lib"
+)
+
+func TryMe() (string, error) {
+	return lib.Fetch("key")
+}
@@ -0,0 +1,28 @@
+package code
+
+import (
+	"testing"
+
+	"github.com/golang/mock/gomock"
+
+	"github.com/qur/withmock/scenarios/config_strict/lib" // mock
+)
+
+func TestTryMe(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	lib.MOCK().SetController(ctrl)
+	lib.EXPECT().Fetch("key").Return("value", nil)
+
+	// Run the function we want to test
+	value, err := TryMe()
+
+	if err != nil {
+		t.Errorf("Unexpected error return: %s", err)
+	}
+
+	if value != "value" {
+		t.Errorf("Expected 'value', got '%s'", value)
+	}
+}
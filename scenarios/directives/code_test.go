@@ -0,0 +1,36 @@
+package code
+
+//withmock:mock github.com/qur/withmock/scenarios/directives/lib
+//withmock:replace github.com/qur/withmock/scenarios/directives/lib2 => github.com/qur/withmock/scenarios/directives/lib2_fake
+
+import (
+	"testing"
+
+	"github.com/golang/mock/gomock"
+
+	// Both of these are handled by the directives above, rather than by a
+	// comment - and a comment on the import that isn't a mark doesn't stop
+	// the directive from applying
+	"github.com/qur/withmock/scenarios/directives/lib" // used by TryMe
+	_ "github.com/qur/withmock/scenarios/directives/lib2"
+)
+
+func TestTryMe(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	lib.MOCK().SetController(ctrl)
+
+	lib.EXPECT().Fetch("fake").Return("value", nil)
+
+	// Run the function we want to test
+	value, err := TryMe()
+
+	if err != nil {
+		t.Errorf("Unexpected error return: %s", err)
+	}
+
+	if value != "value" {
+		t.Errorf("Expected 'value', got '%s'", value)
+	}
+}
@@ -0,0 +1,21 @@
+package code
+
+import (
+	"github.com/qur/withmock/scenarios/fixture/lib"
+)
+
+func TryMe(keys ...string) (int, error) {
+	total := 0
+	for _, key := range keys {
+		r, err := lib.Lookup(key)
+		if err != nil {
+			return total, err
+		}
+		total += r.Count
+	}
+	return total, nil
+}
+
+func Double(x float64) float64 {
+	return lib.Scale(x)
+}
@@ -0,0 +1,57 @@
+package code
+
+import (
+	"testing"
+
+	"github.com/golang/mock/gomock"
+
+	"github.com/qur/withmock/scenarios/fixture/lib" // mock
+)
+
+func TestTryMe(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	lib.MOCK().SetController(ctrl)
+
+	// Calls are recorded into, or replayed from, the fixture for this test
+	lib.MOCK().Fixture(t)
+
+	// Run the function we want to test
+	ret, err := TryMe("a", "b", "c")
+
+	if ret != 3 {
+		t.Errorf("TryMe returned %d, not 3", ret)
+	}
+
+	if err == nil || err.Error() != "no record for c" {
+		t.Errorf("TryMe returned unexpected error: %v", err)
+	}
+}
+
+func TestDouble(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	lib.MOCK().SetController(ctrl)
+	lib.MOCK().Fixture(t)
+
+	// A whole float64 has to be learnt as 1.0, not 1 (which is an int)
+	if ret := Double(1); ret != 2 {
+		t.Errorf("Double returned %v, not 2", ret)
+	}
+}
+
+func TestDoubleLearned(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	lib.MOCK().SetController(ctrl)
+
+	// This is what learn mode generates for TestDouble
+	lib.EXPECT().Scale(1.0).Return(2.0)
+
+	if ret := Double(1); ret != 2 {
+		t.Errorf("Double returned %v, not 2", ret)
+	}
+}
@@ -0,0 +1,24 @@
+package lib
+
+import (
+	"fmt"
+)
+
+type Record struct {
+	Key   string
+	Count int
+}
+
+var db = map[string]int{"a": 1, "b": 2}
+
+func Lookup(key string) (*Record, error) {
+	count, found := db[key]
+	if !found {
+		return nil, fmt.Errorf("no record for %s", key)
+	}
+	return &Record{key, count}, nil
+}
+
+func Scale(x float64) float64 {
+	return x * 2
+}
@@ -14,6 +14,28 @@ func TestShow(t *testing.T) {
 
 	lib.MOCK().SetController(ctrl)
 
+	// Wibble is a function variable, and so is mocked too - but we want the
+	// original function literal to be called.
+	lib.MOCK().DisableMock("Wibble")
+
+	lib.EXPECT().Bar().Return(nil)
+
+	// Run the function we want to test
+	err := TryMe()
+
+	if err != nil {
+		t.Errorf("Unexpected error return: %s", err)
+	}
+}
+
+func TestMocked(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	lib.MOCK().SetController(ctrl)
+	lib.MOCK().MockAll(true)
+
+	lib.EXPECT().Wibble()
 	lib.EXPECT().Bar().Return(nil)
 
 	// Run the function we want to test
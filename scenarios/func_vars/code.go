@@ -0,0 +1,22 @@
+package code
+
+import (
+	"time"
+
+	"github.com/qur/withmock/scenarios/func_vars/lib"
+)
+
+func TryMe(addr string) (string, error) {
+	conn, err := lib.Dial(addr)
+	if err != nil {
+		return "", err
+	}
+	return conn + "@" + lib.Now().Format(time.RFC3339), nil
+}
+
+func Notify(name string) error {
+	if lib.OnEvent == nil {
+		return nil
+	}
+	return lib.OnEvent(name)
+}
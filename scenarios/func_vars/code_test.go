@@ -0,0 +1,63 @@
+package code
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+
+	"github.com/qur/withmock/scenarios/func_vars/lib" // mock
+)
+
+func TestTryMe(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	lib.MOCK().SetController(ctrl)
+
+	when := time.Date(2014, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	lib.EXPECT().Dial("example.com:80").Return("conn", nil)
+	lib.EXPECT().Now().Return(when)
+
+	// Run the function we want to test
+	ret, err := TryMe("example.com:80")
+
+	if err != nil {
+		t.Fatalf("Unexpected error return: %s", err)
+	}
+
+	if ret != "conn@2014-01-02T03:04:05Z" {
+		t.Errorf("TryMe returned '%s'", ret)
+	}
+}
+
+func TestNotFuncs(t *testing.T) {
+	rec := reflect.TypeOf(lib.EXPECT())
+
+	for _, name := range []string{"ErrNotFound", "Version", "Default"} {
+		if _, found := rec.MethodByName(name); found {
+			t.Errorf("Unexpected recorder method for %s", name)
+		}
+	}
+}
+
+func TestNilFuncVar(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	lib.MOCK().SetController(ctrl)
+
+	// Until there is an expectation the variable is still nil
+	if err := Notify("a"); err != nil {
+		t.Fatalf("Unexpected error return: %s", err)
+	}
+
+	lib.EXPECT().OnEvent("b").Return(errors.New("failed"))
+
+	if err := Notify("b"); err == nil || err.Error() != "failed" {
+		t.Errorf("Notify returned unexpected error: %v", err)
+	}
+}
@@ -0,0 +1,29 @@
+package lib
+
+import (
+	"fmt"
+	"os"
+	"time"
+)
+
+var Now = time.Now
+
+var Dial func(addr string) (string, error) = dial
+
+func dial(addr string) (string, error) {
+	return "", fmt.Errorf("can't dial %s", addr)
+}
+
+// OnEvent is nil until something sets it
+var OnEvent func(name string) error
+
+// These hold values that aren't functions, so they shouldn't get recorders
+var ErrNotFound = os.ErrNotExist
+
+var Version = version
+
+var Default = defaultConfig
+
+const version = "1.0"
+
+var defaultConfig = map[string]string{}
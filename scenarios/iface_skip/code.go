@@ -0,0 +1,13 @@
+package code
+
+import (
+	"github.com/qur/withmock/scenarios/iface_skip/lib"
+)
+
+func Describe(n lib.Namer) string {
+	return n.Name()
+}
+
+func Total(a, b int) int {
+	return lib.Sum(a, b)
+}
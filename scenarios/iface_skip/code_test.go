@@ -0,0 +1,36 @@
+package code
+
+import (
+	"testing"
+
+	"github.com/golang/mock/gomock"
+
+	"github.com/qur/withmock/scenarios/iface_skip/lib" // mock
+)
+
+func TestDescribe(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	lib.MOCK().SetController(ctrl)
+
+	n := lib.MOCK().NewNamer()
+	n.EXPECT().Name().Return("namer")
+
+	if s := Describe(n); s != "namer" {
+		t.Errorf("Expected 'namer', got '%s'", s)
+	}
+}
+
+func TestTotal(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	lib.MOCK().SetController(ctrl)
+
+	lib.EXPECT().Sum(1, 2).Return(4)
+
+	if n := Total(1, 2); n != 4 {
+		t.Errorf("Expected 4, got %d", n)
+	}
+}
@@ -0,0 +1,27 @@
+package lib
+
+type Number interface {
+	~int | ~float64
+}
+
+type Key interface {
+	comparable
+	String() string
+}
+
+type Getter[T any] interface {
+	Get() T
+}
+
+type IntGetter interface {
+	Getter[int]
+}
+
+type Namer interface {
+	any
+	Name() string
+}
+
+func Sum(a, b int) int {
+	return a + b
+}
@@ -0,0 +1,9 @@
+package code
+
+import (
+	"github.com/qur/withmock/scenarios/ignore_inits/lib"
+)
+
+func TryMe() error {
+	return lib.Count("tries")
+}
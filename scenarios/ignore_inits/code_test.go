@@ -0,0 +1,23 @@
+package code
+
+import (
+	"testing"
+
+	"github.com/golang/mock/gomock"
+
+	"github.com/qur/withmock/scenarios/ignore_inits/lib" // mock
+)
+
+func TestTryMe(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	lib.MOCK().SetController(ctrl)
+
+	lib.EXPECT().Count("tries").Return(nil)
+
+	// Run the function we want to test
+	if err := TryMe(); err != nil {
+		t.Errorf("Unexpected error return: %s", err)
+	}
+}
@@ -0,0 +1,20 @@
+package lib
+
+import (
+	"net"
+)
+
+var conn net.Conn
+
+func init() {
+	var err error
+	conn, err = net.Dial("tcp", "metrics.invalid:8125")
+	if err != nil {
+		panic(err)
+	}
+}
+
+func Count(name string) error {
+	_, err := conn.Write([]byte(name + ":1|c"))
+	return err
+}
@@ -0,0 +1,27 @@
+package code
+
+import (
+	"github.com/qur/withmock/scenarios/import_forms/lib"
+	"github.com/qur/withmock/scenarios/import_forms/lib2"
+	"github.com/qur/withmock/scenarios/import_forms/lib3"
+	"github.com/qur/withmock/scenarios/import_forms/lib4"
+	"github.com/qur/withmock/scenarios/import_forms/lib5"
+	"github.com/qur/withmock/scenarios/import_forms/lib6"
+)
+
+func TryMe() ([]string, error) {
+	values := []string{}
+
+	for _, fetch := range []func(string) (string, error){
+		lib.Fetchlib, lib2.Fetchlib2, lib3.Fetchlib3, lib4.Fetchlib4,
+		lib5.Fetchlib5, lib6.Fetchlib6,
+	} {
+		value, err := fetch("key")
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, value)
+	}
+
+	return values, nil
+}
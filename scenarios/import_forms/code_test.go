@@ -0,0 +1,55 @@
+package code
+
+import (
+	"testing"
+
+	"github.com/golang/mock/gomock"
+
+	// A dot import is mocked, with MOCK and EXPECT used unqualified
+	. "github.com/qur/withmock/scenarios/import_forms/lib" // mock
+
+	// More than one dot import can be mocked (mock.yml renames MOCK and
+	// EXPECT for lib5 so that they don't clash)
+	. "github.com/qur/withmock/scenarios/import_forms/lib5" // mock
+
+	// A _mock_/ prefix marks the import without needing a comment
+	"_mock_/github.com/qur/withmock/scenarios/import_forms/lib2"
+
+	// A blank import can't be controlled, so the mocks are left disabled
+	_ "github.com/qur/withmock/scenarios/import_forms/lib3" // mock
+	_ "github.com/qur/withmock/scenarios/import_forms/lib6" // mock
+
+	// A raw string import path works the same as a normal one (gofmt would
+	// turn this into a normal string, so don't run it on this file)
+	lib4 `github.com/qur/withmock/scenarios/import_forms/lib4` // mock
+)
+
+func TestTryMe(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	MOCK().SetController(ctrl)
+	MOCK5().SetController(ctrl)
+	lib2.MOCK().SetController(ctrl)
+	lib4.MOCK().SetController(ctrl)
+
+	EXPECT().Fetchlib("key").Return("lib", nil)
+	EXPECT5().Fetchlib5("key").Return("lib5", nil)
+	lib2.EXPECT().Fetchlib2("key").Return("lib2", nil)
+	lib4.EXPECT().Fetchlib4("key").Return("lib4", nil)
+
+	// Run the function we want to test
+	values, err := TryMe()
+
+	if err != nil {
+		t.Fatalf("Unexpected error return: %s", err)
+	}
+
+	expected := []string{"lib", "lib2", "real:key", "lib4", "lib5",
+		"real6:key"}
+	for i := range expected {
+		if values[i] != expected[i] {
+			t.Errorf("Expected '%s', got '%s'", expected[i], values[i])
+		}
+	}
+}
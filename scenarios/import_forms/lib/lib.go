@@ -0,0 +1,9 @@
+package lib
+
+import (
+	"fmt"
+)
+
+func Fetchlib(key string) (string, error) {
+	return "", fmt.Errorf("Not Mocked!")
+}
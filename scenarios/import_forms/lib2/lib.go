@@ -0,0 +1,9 @@
+package lib2
+
+import (
+	"fmt"
+)
+
+func Fetchlib2(key string) (string, error) {
+	return "", fmt.Errorf("Not Mocked!")
+}
@@ -0,0 +1,5 @@
+package lib3
+
+func Fetchlib3(key string) (string, error) {
+	return "real:" + key, nil
+}
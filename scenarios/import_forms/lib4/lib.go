@@ -0,0 +1,9 @@
+package lib4
+
+import (
+	"fmt"
+)
+
+func Fetchlib4(key string) (string, error) {
+	return "", fmt.Errorf("Not Mocked!")
+}
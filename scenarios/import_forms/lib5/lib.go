@@ -0,0 +1,9 @@
+package lib5
+
+import (
+	"fmt"
+)
+
+func Fetchlib5(key string) (string, error) {
+	return "", fmt.Errorf("Not Mocked!")
+}
@@ -0,0 +1,5 @@
+package lib6
+
+func Fetchlib6(key string) (string, error) {
+	return "real6:" + key, nil
+}
@@ -0,0 +1,13 @@
+package code
+
+import (
+	"github.com/qur/withmock/scenarios/instance/lib"
+)
+
+func TryMe(a, b *lib.Client) string {
+	return a.Name() + "," + b.Name()
+}
+
+func Count(v lib.Value) int {
+	return len(v.Get().([]string))
+}
@@ -0,0 +1,41 @@
+package code
+
+import (
+	"testing"
+
+	"github.com/golang/mock/gomock"
+
+	"github.com/qur/withmock/scenarios/instance/lib" // mock
+)
+
+func TestTryMe(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	lib.MOCK().SetController(ctrl)
+
+	// Nothing is mocked unless asked for
+	lib.MOCK().MockAll(false)
+
+	a := lib.NewClient("a")
+	b := lib.NewClient("b")
+
+	// Only mock the Name method of a, b stays real
+	lib.MOCK().EnableMockFor(a, "Client.Name")
+
+	a.EXPECT().Name().Return("mocked")
+
+	// Run the function we want to test
+	ret := TryMe(a, b)
+
+	if ret != "mocked,b" {
+		t.Errorf("TryMe returned '%s', not 'mocked,b'", ret)
+	}
+
+	// A value that can't be used as a map key is just left alone
+	v := lib.NewValue([]string{"x", "y"})
+
+	if n := Count(v); n != 2 {
+		t.Errorf("Count returned %d, not 2", n)
+	}
+}
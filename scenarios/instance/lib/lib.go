@@ -0,0 +1,25 @@
+package lib
+
+type Client struct {
+	name string
+}
+
+func NewClient(name string) *Client {
+	return &Client{name}
+}
+
+func (c *Client) Name() string {
+	return c.name
+}
+
+type Value struct {
+	v interface{}
+}
+
+func NewValue(v interface{}) Value {
+	return Value{v}
+}
+
+func (v Value) Get() interface{} {
+	return v.v
+}
@@ -0,0 +1,13 @@
+package code
+
+import (
+	"github.com/qur/withmock/scenarios/loose/logger"
+)
+
+func TryMe(a, b int) int {
+	logger.Logf("adding %d and %d", a, b)
+	if logger.Level() > 2 {
+		logger.Logf("result is %d", a+b)
+	}
+	return a + b
+}
@@ -0,0 +1,72 @@
+package code
+
+import (
+	"testing"
+
+	"github.com/golang/mock/gomock"
+
+	"github.com/qur/withmock/scenarios/loose/logger" // mock
+)
+
+func TestLooseZero(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	logger.MOCK().SetController(ctrl)
+	logger.MOCK().LooseAll(true)
+	logger.MOCK().LooseReal(false)
+
+	// No expectations, so Level returns 0 and only one Logf call is made
+	ret := TryMe(1, 2)
+
+	if ret != 3 {
+		t.Errorf("TryMe returned %d, not 3", ret)
+	}
+
+	if calls := logger.MOCK().Calls("Logf"); len(calls) != 1 {
+		t.Errorf("Expected 1 call to Logf, got %d", len(calls))
+	}
+}
+
+func TestLooseReal(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	logger.MOCK().SetController(ctrl)
+	logger.MOCK().LooseAll(false)
+	logger.MOCK().EnableLoose("Level")
+	logger.MOCK().LooseReal(true)
+
+	// Level falls through to the real code, but Logf is strict
+	logger.EXPECT().Logf("adding %d and %d", 1, 2)
+	logger.EXPECT().Logf("result is %d", 3)
+
+	ret := TryMe(1, 2)
+
+	if ret != 3 {
+		t.Errorf("TryMe returned %d, not 3", ret)
+	}
+
+	calls := logger.MOCK().Calls("Level")
+	if len(calls) != 1 || calls[0].Results[0] != 3 {
+		t.Errorf("Unexpected calls to Level: %v", calls)
+	}
+}
+
+func TestLooseWithExpectation(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	logger.MOCK().SetController(ctrl)
+	logger.MOCK().LooseAll(true)
+	logger.MOCK().LooseReal(false)
+
+	// Once an expectation is set, calls are checked by the controller
+	logger.EXPECT().Level().Return(1)
+
+	ret := TryMe(1, 2)
+
+	if ret != 3 {
+		t.Errorf("TryMe returned %d, not 3", ret)
+	}
+}
@@ -0,0 +1,13 @@
+package logger
+
+import (
+	"fmt"
+)
+
+func Logf(format string, args ...interface{}) {
+	fmt.Printf(format+"\n", args...)
+}
+
+func Level() int {
+	return 3
+}
@@ -0,0 +1,13 @@
+package client
+
+import (
+	"container/list"
+)
+
+func Count(items ...string) int {
+	l := list.New()
+	for _, item := range items {
+		l.PushBack(item)
+	}
+	return l.Len()
+}
@@ -0,0 +1,9 @@
+package code
+
+import (
+	"github.com/qur/withmock/scenarios/mock_imports/client"
+)
+
+func TryMe() int {
+	return client.Count("a", "b")
+}
@@ -0,0 +1,31 @@
+package code
+
+import (
+	"testing"
+
+	"github.com/golang/mock/gomock"
+
+	// client uses the mocked version of container/list, because of the
+	// mock_imports setting in mock.yml
+	"container/list" // mock(New)
+)
+
+func TestTryMe(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	list.MOCK().SetController(ctrl)
+
+	// Only New is mocked, so the methods of List are still real
+	l := (&list.List{}).Init()
+	l.PushBack("existing")
+
+	list.EXPECT().New().Return(l)
+
+	// Run the function we want to test
+	count := TryMe()
+
+	if count != 3 {
+		t.Errorf("Expected 3, got %d", count)
+	}
+}
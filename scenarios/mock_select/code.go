@@ -0,0 +1,24 @@
+package code
+
+import (
+	"github.com/qur/withmock/scenarios/mock_select/env"
+	"github.com/qur/withmock/scenarios/mock_select/lib"
+)
+
+func TryMe(name string) (string, error) {
+	f, err := lib.Open(name)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	return lib.Stat(f.Name()), nil
+}
+
+func TryEnv(key string) (string, error) {
+	if err := env.Setenv(key, "value"); err != nil {
+		return "", err
+	}
+
+	return env.Getenv(key), nil
+}
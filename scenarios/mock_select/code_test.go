@@ -0,0 +1,55 @@
+package code
+
+import (
+	"testing"
+
+	"github.com/golang/mock/gomock"
+
+	// Only Open and File.Close are mocked, the rest of lib is real
+	"github.com/qur/withmock/scenarios/mock_select/lib" // mock(Open,*File.Close)
+
+	// Everything apart from Getenv is mocked
+	"github.com/qur/withmock/scenarios/mock_select/env" // mock(except=Getenv)
+)
+
+func TestTryMe(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	lib.MOCK().SetController(ctrl)
+
+	f := &lib.File{}
+	lib.EXPECT().Open("file").Return(f, nil)
+	f.EXPECT().Close().Return(nil)
+
+	// Name and Stat aren't mocked, so will be called for real
+	value, err := TryMe("file")
+
+	if err != nil {
+		t.Errorf("Unexpected error return: %s", err)
+	}
+
+	if value != "real:" {
+		t.Errorf("Expected 'real:', got '%s'", value)
+	}
+}
+
+func TestTryEnv(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	env.MOCK().SetController(ctrl)
+
+	env.EXPECT().Setenv("key", "value").Return(nil)
+
+	// Getenv isn't mocked, so will be called for real
+	value, err := TryEnv("key")
+
+	if err != nil {
+		t.Errorf("Unexpected error return: %s", err)
+	}
+
+	if value != "real:key" {
+		t.Errorf("Expected 'real:key', got '%s'", value)
+	}
+}
@@ -0,0 +1,13 @@
+package env
+
+import (
+	"fmt"
+)
+
+func Getenv(key string) string {
+	return "real:" + key
+}
+
+func Setenv(key, value string) error {
+	return fmt.Errorf("Not Mocked!")
+}
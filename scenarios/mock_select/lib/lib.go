@@ -0,0 +1,25 @@
+package lib
+
+import (
+	"fmt"
+)
+
+type File struct {
+	name string
+}
+
+func Open(name string) (*File, error) {
+	return nil, fmt.Errorf("Not Mocked!")
+}
+
+func Stat(name string) string {
+	return "real:" + name
+}
+
+func (f *File) Name() string {
+	return f.name
+}
+
+func (f *File) Close() error {
+	return fmt.Errorf("Not Mocked!")
+}
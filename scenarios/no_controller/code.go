@@ -0,0 +1,14 @@
+package code
+
+import (
+	"github.com/qur/withmock/scenarios/no_controller/lib1"
+	"github.com/qur/withmock/scenarios/no_controller/lib2"
+)
+
+func Name1() string {
+	return lib1.Name()
+}
+
+func Name2() string {
+	return lib2.Name()
+}
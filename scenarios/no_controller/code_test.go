@@ -0,0 +1,62 @@
+package code
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/qur/withmock/scenarios/no_controller/lib1" // mock
+	"github.com/qur/withmock/scenarios/no_controller/lib2" // mock
+)
+
+func TestName1(t *testing.T) {
+	// No controller has been set, so we should get a helpful panic
+	defer func() {
+		msg := fmt.Sprint(recover())
+		if !strings.Contains(msg, "Name called in mocked package") ||
+			!strings.Contains(msg, "SetController") {
+			t.Errorf("Unexpected panic: %s", msg)
+		}
+	}()
+
+	Name1()
+
+	t.Errorf("Expected Name1 to panic")
+}
+
+func TestName2(t *testing.T) {
+	lib2.MOCK().MockAll(true)
+
+	// lib2 is configured to call the real code without a controller
+	if name := Name2(); name != "lib2" {
+		t.Errorf("Name2 returned '%s', not 'lib2'", name)
+	}
+}
+
+func TestExpect(t *testing.T) {
+	defer func() {
+		msg := fmt.Sprint(recover())
+		if !strings.Contains(msg, "expectation for Name") {
+			t.Errorf("Unexpected panic: %s", msg)
+		}
+	}()
+
+	lib1.EXPECT().Name()
+
+	t.Errorf("Expected EXPECT to panic")
+}
+
+func TestInterface(t *testing.T) {
+	// Interface mocks have no real code, so no_controller: real can't help
+	defer func() {
+		msg := fmt.Sprint(recover())
+		if !strings.Contains(msg, "MockNamer.Name used in mocked package") ||
+			!strings.Contains(msg, "SetController") {
+			t.Errorf("Unexpected panic: %s", msg)
+		}
+	}()
+
+	lib2.MOCK().NewNamer().Name()
+
+	t.Errorf("Expected Name to panic")
+}
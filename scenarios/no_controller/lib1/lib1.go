@@ -0,0 +1,5 @@
+package lib1
+
+func Name() string {
+	return "lib1"
+}
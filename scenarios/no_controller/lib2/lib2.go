@@ -0,0 +1,9 @@
+package lib2
+
+func Name() string {
+	return "lib2"
+}
+
+type Namer interface {
+	Name() string
+}
@@ -0,0 +1,9 @@
+package code
+
+import (
+	"github.com/qur/withmock/scenarios/real/lib"
+)
+
+func TryMe(c *lib.Counter) int {
+	return c.Incr() + c.Incr()
+}
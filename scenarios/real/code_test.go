@@ -0,0 +1,34 @@
+package code
+
+import (
+	"testing"
+
+	"github.com/golang/mock/gomock"
+
+	"github.com/qur/withmock/scenarios/real/lib" // mock
+)
+
+func TestTryMe(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	lib.MOCK().SetController(ctrl)
+
+	// Build a real Counter, even though the package is mocked
+	c := lib.MOCK().Real().NewCounter(10)
+
+	c.EXPECT().Incr().Return(1)
+	c.EXPECT().Incr().Return(2)
+
+	// Run the function we want to test
+	ret := TryMe(c)
+
+	if ret != 3 {
+		t.Errorf("TryMe returned %d, not 3", ret)
+	}
+
+	// The real method is still available
+	if ret := c.REAL().Incr(); ret != 11 {
+		t.Errorf("Real Incr returned %d, not 11", ret)
+	}
+}
@@ -0,0 +1,14 @@
+package lib
+
+type Counter struct {
+	count int
+}
+
+func NewCounter(start int) *Counter {
+	return &Counter{start}
+}
+
+func (c *Counter) Incr() int {
+	c.count++
+	return c.count
+}
@@ -0,0 +1,9 @@
+package code
+
+import (
+	"github.com/qur/withmock/scenarios/return_types/lib"
+)
+
+func TryMe(name string) int {
+	return lib.Count(name) * 2
+}
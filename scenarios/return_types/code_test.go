@@ -0,0 +1,50 @@
+package code
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/golang/mock/gomock"
+
+	"github.com/qur/withmock/scenarios/return_types/lib" // mock
+)
+
+type reporter struct {
+	msg string
+}
+
+func (r *reporter) Errorf(format string, args ...interface{}) {
+	r.msg = fmt.Sprintf(format, args...)
+}
+
+func (r *reporter) Fatalf(format string, args ...interface{}) {
+	r.msg = fmt.Sprintf(format, args...)
+	panic(r)
+}
+
+func TestTryMe(t *testing.T) {
+	r := &reporter{}
+	ctrl := gomock.NewController(r)
+
+	lib.MOCK().SetController(ctrl)
+
+	// DoAndReturn doesn't check the types, so this used to return 0
+	lib.EXPECT().Count("a").DoAndReturn(func(name string) string {
+		return "wrong"
+	})
+
+	func() {
+		defer func() {
+			if recover() != r {
+				t.Errorf("Expected the mock to fail the test")
+			}
+		}()
+		TryMe("a")
+	}()
+
+	expected := "Count: return value 0 has type string, expected int"
+	if !strings.Contains(r.msg, expected) {
+		t.Errorf("Unexpected failure message: %s", r.msg)
+	}
+}
@@ -0,0 +1,5 @@
+package lib
+
+func Count(name string) int {
+	return len(name)
+}
@@ -0,0 +1,17 @@
+package code
+
+import (
+	"github.com/qur/withmock/scenarios/self/lib"
+)
+
+func Read(name string) (string, error) {
+	return lib.Fetch(name)
+}
+
+func Load(name string) string {
+	value, err := Read(name)
+	if err != nil {
+		return "default"
+	}
+	return value
+}
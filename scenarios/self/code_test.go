@@ -0,0 +1,31 @@
+// withmock:self
+
+package code
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/golang/mock/gomock"
+)
+
+func TestReal(t *testing.T) {
+	if value := Load("foo"); value != "value of foo" {
+		t.Errorf("Expected 'value of foo', got '%s'", value)
+	}
+}
+
+func TestMocked(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	MOCK().SetController(ctrl)
+	MOCK().EnableMock("Read")
+	defer MOCK().DisableMock("Read")
+
+	EXPECT().Read("foo").Return("", errors.New("not found"))
+
+	if value := Load("foo"); value != "default" {
+		t.Errorf("Expected 'default', got '%s'", value)
+	}
+}
@@ -0,0 +1,5 @@
+package lib
+
+func Fetch(name string) (string, error) {
+	return "value of " + name, nil
+}
@@ -0,0 +1,14 @@
+package code
+
+import (
+	"github.com/qur/withmock/scenarios/spy/store"
+)
+
+func TryMe(key string) string {
+	store.Put(key, "value:"+key)
+	value, err := store.Get(key)
+	if err != nil {
+		return err.Error()
+	}
+	return value
+}
@@ -0,0 +1,63 @@
+package code
+
+import (
+	"testing"
+
+	"github.com/golang/mock/gomock"
+
+	"github.com/qur/withmock/scenarios/spy/store" // mock
+)
+
+func TestSpy(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	store.MOCK().SetController(ctrl)
+	store.MOCK().SpyAll(true)
+
+	// Spied calls run the real code, so no expectations are needed
+	ret := TryMe("foo")
+
+	if ret != "value:foo" {
+		t.Errorf("TryMe returned %s, not value:foo", ret)
+	}
+
+	if !store.MOCK().Called("Put", "foo", "value:foo") {
+		t.Errorf("Expected call to Put not recorded")
+	}
+
+	if !store.MOCK().Called("Get", gomock.Any()) {
+		t.Errorf("Expected call to Get not recorded")
+	}
+
+	calls := store.MOCK().Calls("Get")
+	if len(calls) != 1 || calls[0].Results[0] != "value:foo" {
+		t.Errorf("Unexpected calls to Get: %v", calls)
+	}
+}
+
+func TestSpyOne(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	store.MOCK().SetController(ctrl)
+	store.MOCK().SpyAll(false)
+	store.MOCK().EnableSpy("Put")
+
+	// Get is still mocked
+	store.EXPECT().Get("bar").Return("mocked", nil)
+
+	ret := TryMe("bar")
+
+	if ret != "mocked" {
+		t.Errorf("TryMe returned %s, not mocked", ret)
+	}
+
+	if !store.MOCK().Called("Put", "bar", "value:bar") {
+		t.Errorf("Expected call to Put not recorded")
+	}
+
+	if len(store.MOCK().Calls("Get")) != 0 {
+		t.Errorf("Mocked call to Get should not be recorded")
+	}
+}
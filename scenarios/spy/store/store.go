@@ -0,0 +1,19 @@
+package store
+
+import (
+	"fmt"
+)
+
+var data = map[string]string{}
+
+func Put(key, value string) {
+	data[key] = value
+}
+
+func Get(key string) (string, error) {
+	value, found := data[key]
+	if !found {
+		return "", fmt.Errorf("missing key: %s", key)
+	}
+	return value, nil
+}
@@ -0,0 +1,9 @@
+package code
+
+import (
+	"github.com/qur/withmock/scenarios/trace/lib"
+)
+
+func TryMe(n int) int {
+	return lib.Double(n) + lib.Square(n)
+}
@@ -0,0 +1,60 @@
+package code
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"github.com/golang/mock/gomock"
+
+	"github.com/qur/withmock/scenarios/trace/lib" // mock
+)
+
+func TestTryMe(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	lib.MOCK().SetController(ctrl)
+	lib.MOCK().Trace(t)
+
+	// Only mock Double, Square will call the real code
+	lib.MOCK().MockAll(false)
+	lib.MOCK().EnableMock("Double")
+
+	lib.EXPECT().Double(3).Return(1)
+
+	// Run the function we want to test
+	ret := TryMe(3)
+
+	if ret != 10 {
+		t.Errorf("TryMe returned %d, not 10", ret)
+	}
+
+	calls := lib.MOCK().TraceCalls()
+	if len(calls) != 2 {
+		t.Fatalf("Expected 2 traced calls, got %d", len(calls))
+	}
+	if calls[0].Name != "Double" || calls[0].Route != "mock" {
+		t.Errorf("Expected mocked call to Double, got %s %s", calls[0].Route,
+			calls[0].Name)
+	}
+	if calls[1].Name != "Square" || calls[1].Route != "real" {
+		t.Errorf("Expected real call to Square, got %s %s", calls[1].Route,
+			calls[1].Name)
+	}
+	if calls[1].Results[0] != "9" {
+		t.Errorf("Expected Square to return 9, got %s", calls[1].Results[0])
+	}
+
+	buf := &bytes.Buffer{}
+	if err := lib.MOCK().WriteTraceJSON(buf); err != nil {
+		t.Fatalf("Failed to write trace: %s", err)
+	}
+	entries := []map[string]interface{}{}
+	if err := json.Unmarshal(buf.Bytes(), &entries); err != nil {
+		t.Fatalf("Failed to read trace: %s", err)
+	}
+	if len(entries) != 2 || entries[0]["name"] != "Double" {
+		t.Errorf("Unexpected JSON trace: %s", buf)
+	}
+}
@@ -0,0 +1,9 @@
+package lib
+
+func Double(n int) int {
+	return n * 2
+}
+
+func Square(n int) int {
+	return n * n
+}
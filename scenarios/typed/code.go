@@ -0,0 +1,16 @@
+package code
+
+import (
+	"github.com/qur/withmock/scenarios/typed/lib"
+)
+
+func TryMe(keys ...string) int {
+	s := lib.NewStore()
+	total := 0
+	for _, key := range keys {
+		if value, found := s.Get(key); found {
+			total += value
+		}
+	}
+	return total
+}
@@ -0,0 +1,33 @@
+package code
+
+import (
+	"testing"
+
+	"github.com/golang/mock/gomock"
+
+	"github.com/qur/withmock/scenarios/typed/lib" // mock
+)
+
+func TestTryMe(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	lib.MOCK().SetController(ctrl)
+
+	s := lib.MOCK().Real().NewStore()
+
+	// Return and DoAndReturn are type checked by the compiler
+	lib.EXPECT().NewStore().Return(s)
+	s.EXPECT().Get("a").Return(1, true)
+	s.EXPECT().Get("b").DoAndReturn(func(key string) (int, bool) {
+		return 2, true
+	})
+	s.EXPECT().Get("c").Return(0, false)
+
+	// Run the function we want to test
+	ret := TryMe("a", "b", "c")
+
+	if ret != 3 {
+		t.Errorf("TryMe returned %d, not 3", ret)
+	}
+}
@@ -0,0 +1,14 @@
+package lib
+
+type Store struct {
+	data map[string]int
+}
+
+func NewStore() *Store {
+	return &Store{make(map[string]int)}
+}
+
+func (s *Store) Get(key string) (int, bool) {
+	value, found := s.data[key]
+	return value, found
+}
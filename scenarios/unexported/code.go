@@ -0,0 +1,9 @@
+package code
+
+import (
+	"github.com/qur/withmock/scenarios/unexported/lib"
+)
+
+func TryMe(c *lib.Counter) int {
+	return lib.Sum(1, 2) + c.Add(3)
+}
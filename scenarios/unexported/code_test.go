@@ -0,0 +1,34 @@
+package code
+
+import (
+	"testing"
+
+	"github.com/golang/mock/gomock"
+
+	"github.com/qur/withmock/scenarios/unexported/lib" // mock
+)
+
+func TestTryMe(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	lib.MOCK().SetController(ctrl)
+
+	// Use the real exported functions, so that we can check the unexported
+	// ones that they call.
+	lib.MOCK().DisableMock("Sum", "Counter.Add")
+
+	c := &lib.Counter{}
+
+	lib.MOCK().Unexported().Add(0, 1).Return(10)
+	lib.MOCK().Unexported().Add(10, 2).Return(20)
+	lib.MOCK().Unexported().Counter(c).Incr(3).Return(5)
+
+	// Run the function we want to test
+	ret := TryMe(c)
+
+	// Counter.Add returns the real count, as the mocked incr didn't change it
+	if ret != 20 {
+		t.Errorf("TryMe returned %d, not 20", ret)
+	}
+}
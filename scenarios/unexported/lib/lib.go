@@ -0,0 +1,29 @@
+package lib
+
+type Counter struct {
+	count int
+}
+
+func (c *Counter) incr(n int) int {
+	c.count += n
+	return c.count
+}
+
+func (c *Counter) Add(nums ...int) int {
+	for _, n := range nums {
+		c.incr(n)
+	}
+	return c.count
+}
+
+func add(a, b int) int {
+	return a + b
+}
+
+func Sum(nums ...int) int {
+	total := 0
+	for _, n := range nums {
+		total = add(total, n)
+	}
+	return total
+}
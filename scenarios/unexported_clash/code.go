@@ -0,0 +1,9 @@
+package code
+
+import (
+	"github.com/qur/withmock/scenarios/unexported_clash/lib"
+)
+
+func TryMe(key string) string {
+	return lib.Get("a", key)
+}
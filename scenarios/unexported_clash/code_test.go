@@ -0,0 +1,33 @@
+package code
+
+import (
+	"testing"
+
+	"github.com/golang/mock/gomock"
+
+	"github.com/qur/withmock/scenarios/unexported_clash/lib" // mock
+)
+
+func TestTryMe(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	lib.MOCK().SetController(ctrl)
+
+	// Use the real Get, so that we can check the unexported code it calls
+	lib.MOCK().DisableMock("Get")
+
+	c := &lib.Client{}
+
+	// The recorder for client is Client, so the recorder for the methods of
+	// the Client type has to be ClientType instead.
+	lib.MOCK().Unexported().Client("a").Return(c)
+	lib.MOCK().Unexported().ClientType(c).Fetch("key").Return("value")
+
+	// Run the function we want to test
+	ret := TryMe("key")
+
+	if ret != "value" {
+		t.Errorf("TryMe returned '%s', not 'value'", ret)
+	}
+}
@@ -0,0 +1,17 @@
+package lib
+
+type Client struct {
+	name string
+}
+
+func client(name string) *Client {
+	return &Client{name}
+}
+
+func (c *Client) fetch(key string) string {
+	return c.name + ":" + key
+}
+
+func Get(name, key string) string {
+	return client(name).fetch(key)
+}
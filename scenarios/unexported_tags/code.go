@@ -0,0 +1,9 @@
+package code
+
+import (
+	"github.com/qur/withmock/scenarios/unexported_tags/lib"
+)
+
+func TryMe(c *lib.Counter) int {
+	return c.Add(3)
+}
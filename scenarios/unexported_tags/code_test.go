@@ -0,0 +1,31 @@
+package code
+
+import (
+	"testing"
+
+	"github.com/golang/mock/gomock"
+
+	"github.com/qur/withmock/scenarios/unexported_tags/lib" // mock
+)
+
+func TestTryMe(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	lib.MOCK().SetController(ctrl)
+
+	// Use the real exported methods, so that we can check the unexported
+	// method that they call, which is in a file with build tags.
+	lib.MOCK().DisableMock("Counter.Add", "Counter.Inner")
+
+	c := &lib.Counter{}
+
+	lib.MOCK().Unexported().Counter(c.Inner()).Incr(3).Return(5)
+
+	// Run the function we want to test
+	ret := TryMe(c)
+
+	if ret != 5 {
+		t.Errorf("TryMe returned %d, not 5", ret)
+	}
+}
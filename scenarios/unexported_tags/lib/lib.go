@@ -0,0 +1,17 @@
+package lib
+
+type Counter struct {
+	c counter
+}
+
+type counter struct {
+	count int
+}
+
+func (c *Counter) Inner() *counter {
+	return &c.c
+}
+
+func (c *Counter) Add(n int) int {
+	return c.c.incr(n)
+}
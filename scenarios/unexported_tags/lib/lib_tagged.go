@@ -0,0 +1,8 @@
+// +build !withmock_never
+
+package lib
+
+func (c *counter) incr(n int) int {
+	c.count += n
+	return c.count
+}